@@ -0,0 +1,404 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../binaries"
+	"../graph"
+	"../index"
+	"../segment"
+)
+
+const blockSize = 512
+
+// Writer writes a TAR file in the same layout used by the Segment Store. The
+// segments are stored as entries named after their ID and checksum. When the
+// writer is closed, the index of binary references, the graph and the index
+// are appended to the TAR file, followed by the two zero blocks marking the end
+// of the archive.
+type Writer struct {
+	// ModTime is the modification time written in the header of every
+	// entry. If zero, the current time is used.
+	ModTime time.Time
+
+	w        io.Writer
+	name     string
+	offset   int
+	entries  map[[2]uint64]int
+	index    index.Index
+	graph    graph.Graph
+	binaries binaries.Binaries
+	closed   bool
+}
+
+// NewWriter returns a writer for a TAR file called 'name', whose content is
+// written to 'w'. The name is used to derive the names of the metadata entries.
+func NewWriter(w io.Writer, name string) *Writer {
+	return &Writer{
+		w:        w,
+		name:     name,
+		entries:  make(map[[2]uint64]int),
+		index:    index.Index{Version: index.V2},
+		binaries: binaries.Binaries{Version: binaries.V2},
+	}
+}
+
+// WriteSegment writes a segment entry to the TAR file and adds it to the index.
+// The references of a data segment are added to the graph. The generation
+// information is only used for the index and the binary references, and must
+// match the header of data segments.
+func (w *Writer) WriteSegment(msb, lsb uint64, generation, fullGeneration int, compacted bool, data []byte) error {
+	if w.closed {
+		return fmt.Errorf("writer closed")
+	}
+
+	if _, ok := w.findEntry(msb, lsb); ok {
+		return fmt.Errorf("duplicate segment %016x%016x", msb, lsb)
+	}
+
+	if isDataSegment(lsb) {
+		var s segment.Segment
+
+		if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("invalid data segment %016x%016x: %v", msb, lsb, err)
+		}
+
+		var references []graph.Reference
+
+		for _, r := range s.References {
+			references = append(references, graph.Reference(r))
+		}
+
+		w.graph.AddReferences(msb, lsb, references)
+	}
+
+	name := fmt.Sprintf("%s.%08x", uuid(msb, lsb), crc32.ChecksumIEEE(data))
+
+	if err := w.writeHeader(name, len(data)); err != nil {
+		return err
+	}
+
+	w.entries[[2]uint64{msb, lsb}] = len(w.index.Entries)
+
+	w.index.Entries = append(w.index.Entries, index.Entry{
+		Msb:            msb,
+		Lsb:            lsb,
+		Position:       w.offset,
+		Size:           len(data),
+		Generation:     generation,
+		FullGeneration: fullGeneration,
+		Compacted:      compacted,
+	})
+
+	if err := w.write(data); err != nil {
+		return err
+	}
+
+	return w.write(make([]byte, padding(len(data))))
+}
+
+// AddBinaryReference records a reference to an external binary from a segment
+// previously written to the TAR file.
+func (w *Writer) AddBinaryReference(msb, lsb uint64, reference string) error {
+	if w.closed {
+		return fmt.Errorf("writer closed")
+	}
+
+	e, ok := w.findEntry(msb, lsb)
+
+	if !ok {
+		return fmt.Errorf("segment %016x%016x not found", msb, lsb)
+	}
+
+	var g *binaries.Generation
+
+	for i := range w.binaries.Generations {
+		c := &w.binaries.Generations[i]
+		if c.Generation == e.Generation && c.FullGeneration == e.FullGeneration && c.Compacted == e.Compacted {
+			g = c
+			break
+		}
+	}
+
+	if g == nil {
+		w.binaries.Generations = append(w.binaries.Generations, binaries.Generation{
+			Generation:     e.Generation,
+			FullGeneration: e.FullGeneration,
+			Compacted:      e.Compacted,
+		})
+		g = &w.binaries.Generations[len(w.binaries.Generations)-1]
+	}
+
+	var s *binaries.Segment
+
+	for i := range g.Segments {
+		if g.Segments[i].Msb == msb && g.Segments[i].Lsb == lsb {
+			s = &g.Segments[i]
+			break
+		}
+	}
+
+	if s == nil {
+		g.Segments = append(g.Segments, binaries.Segment{Msb: msb, Lsb: lsb})
+		s = &g.Segments[len(g.Segments)-1]
+	}
+
+	s.References = append(s.References, reference)
+
+	return nil
+}
+
+// Size returns the number of bytes written so far.
+func (w *Writer) Size() int {
+	return w.offset
+}
+
+// Close writes the metadata entries and the end of the archive. It doesn't
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	// The entries are sorted in a copy of the index, since 'entries' refers
+	// to their positions in the order they were written.
+
+	idx := index.Index{
+		Version: w.index.Version,
+		Entries: append([]index.Entry(nil), w.index.Entries...),
+	}
+
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Msb != b.Msb {
+			return int64(a.Msb) < int64(b.Msb)
+		}
+		return int64(a.Lsb) < int64(b.Lsb)
+	})
+
+	if err := w.writeMetadata(".brf", &w.binaries); err != nil {
+		return err
+	}
+
+	if err := w.writeMetadata(".gph", &w.graph); err != nil {
+		return err
+	}
+
+	if err := w.writeMetadata(".idx", &idx); err != nil {
+		return err
+	}
+
+	return w.write(make([]byte, 2*blockSize))
+}
+
+// writeMetadata writes an entry whose content is padded at the beginning, so
+// that it ends at the end of a block and can be read backwards from there.
+func (w *Writer) writeMetadata(suffix string, wt io.WriterTo) error {
+	var b bytes.Buffer
+
+	if _, err := wt.WriteTo(&b); err != nil {
+		return err
+	}
+
+	p := padding(b.Len())
+
+	if err := w.writeHeader(w.name+suffix, b.Len()+p); err != nil {
+		return err
+	}
+
+	if err := w.write(make([]byte, p)); err != nil {
+		return err
+	}
+
+	return w.write(b.Bytes())
+}
+
+func (w *Writer) writeHeader(name string, size int) error {
+	if len(name) > 100 {
+		return fmt.Errorf("name too long: %s", name)
+	}
+
+	modTime := w.ModTime
+
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	header := make([]byte, blockSize)
+
+	copy(header[0:], name)
+	copy(header[100:], fmt.Sprintf("%07o", 0400))
+	copy(header[108:], fmt.Sprintf("%07o", 0))
+	copy(header[116:], fmt.Sprintf("%07o", 0))
+	copy(header[124:], fmt.Sprintf("%011o", size))
+	copy(header[136:], fmt.Sprintf("%011o", modTime.Unix()))
+	copy(header[148:], "        ")
+	header[156] = '0'
+
+	checksum := 0
+
+	for _, b := range header {
+		checksum += int(b)
+	}
+
+	copy(header[148:], fmt.Sprintf("%06o\x00 ", checksum))
+
+	return w.write(header)
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += n
+	return err
+}
+
+// Verify reads a TAR file from 'r' and checks its index against the entries
+// actually stored in the archive. Every entry in the index must point to the
+// data of the segment with the same ID and have the same size, and every
+// segment in the archive must be indexed. The checksum in the name of every
+// segment is verified too.
+func Verify(r io.Reader) error {
+	type segmentEntry struct {
+		position int
+		size     int
+	}
+
+	var (
+		cr       = &countingReader{r: r}
+		tr       = tar.NewReader(cr)
+		segments = make(map[string]segmentEntry)
+		idx      *index.Index
+	)
+
+	for {
+		h, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		position := cr.n
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", h.Name, err)
+		}
+
+		if strings.HasSuffix(h.Name, ".idx") {
+			idx = new(index.Index)
+
+			if _, err := idx.ReadFrom(bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("unable to read the index: %v", err)
+			}
+
+			continue
+		}
+
+		id, checksum, ok := parseSegmentName(h.Name)
+
+		if !ok {
+			continue
+		}
+
+		if actual := crc32.ChecksumIEEE(data); actual != checksum {
+			return fmt.Errorf("segment %s: checksum %08x, expected %08x", id, actual, checksum)
+		}
+
+		segments[id] = segmentEntry{position: position, size: len(data)}
+	}
+
+	if idx == nil {
+		return fmt.Errorf("index not found")
+	}
+
+	for _, e := range idx.Entries {
+		id := uuid(e.Msb, e.Lsb)
+
+		s, ok := segments[id]
+
+		if !ok {
+			return fmt.Errorf("segment %s: indexed but not in the archive", id)
+		}
+
+		if e.Position != s.position {
+			return fmt.Errorf("segment %s: indexed at position %d, stored at %d", id, e.Position, s.position)
+		}
+
+		if e.Size != s.size {
+			return fmt.Errorf("segment %s: indexed with size %d, stored with size %d", id, e.Size, s.size)
+		}
+	}
+
+	if len(idx.Entries) != len(segments) {
+		return fmt.Errorf("%d segments in the index, %d in the archive", len(idx.Entries), len(segments))
+	}
+
+	return nil
+}
+
+// parseSegmentName splits the name of a segment entry into the UUID of the
+// segment and the checksum of its content.
+func parseSegmentName(name string) (string, uint32, bool) {
+	i := strings.IndexByte(name, '.')
+
+	if i != 36 {
+		return "", 0, false
+	}
+
+	checksum, err := strconv.ParseUint(name[i+1:], 16, 32)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return name[:i], uint32(checksum), true
+}
+
+// countingReader counts the bytes read from the underlying reader. Right after
+// a header has been read, the count is the position of the entry's data.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (w *Writer) findEntry(msb, lsb uint64) (index.Entry, bool) {
+	if i, ok := w.entries[[2]uint64{msb, lsb}]; ok {
+		return w.index.Entries[i], true
+	}
+	return index.Entry{}, false
+}
+
+func isDataSegment(lsb uint64) bool {
+	return lsb>>60 == 0xa
+}
+
+func padding(n int) int {
+	if r := n % blockSize; r > 0 {
+		return blockSize - r
+	}
+	return 0
+}
+
+func uuid(msb, lsb uint64) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", msb>>32, (msb>>16)&0xffff, msb&0xffff, lsb>>48, lsb&0xffffffffffff)
+}
@@ -0,0 +1,232 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"../index"
+	"../segment"
+)
+
+type testSegment struct {
+	msb, lsb uint64
+	data     []byte
+}
+
+func testSegments(t *testing.T) []testSegment {
+	t.Helper()
+
+	builder := &segment.Builder{Version: 13, Generation: 1, FullGeneration: 1}
+
+	builder.AddReference(segment.Reference{Msb: 3, Lsb: 0xb000000000000004})
+
+	if _, err := builder.AddRecord(segment.RecordTypeValue, []byte("value")); err != nil {
+		t.Fatalf("unable to add the record: %v", err)
+	}
+
+	var b bytes.Buffer
+
+	if _, err := builder.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	return []testSegment{
+		{msb: 1, lsb: 0xa000000000000002, data: b.Bytes()},
+		{msb: 3, lsb: 0xb000000000000004, data: bytes.Repeat([]byte{1}, 1000)},
+		{msb: 0x8000000000000005, lsb: 0xb000000000000006, data: bytes.Repeat([]byte{2}, 512)},
+	}
+}
+
+func writeArchive(t *testing.T, segments []testSegment, before func(w *Writer)) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w := NewWriter(&b, "data00000a.tar")
+	w.ModTime = time.Unix(1000, 0)
+
+	for _, s := range segments {
+		if err := w.WriteSegment(s.msb, s.lsb, 1, 1, false, s.data); err != nil {
+			t.Fatalf("unable to write the segment: %v", err)
+		}
+	}
+
+	if before != nil {
+		before(w)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close the archive: %v", err)
+	}
+
+	if b.Len() != w.Size() {
+		t.Fatalf("unexpected archive size: got %d, want %d", b.Len(), w.Size())
+	}
+
+	return b.Bytes()
+}
+
+func TestWriterIndex(t *testing.T) {
+	segments := testSegments(t)
+
+	data := writeArchive(t, segments, nil)
+
+	var (
+		tr    = tar.NewReader(bytes.NewReader(data))
+		names []string
+		idx   index.Index
+	)
+
+	for {
+		h, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("unable to read the archive: %v", err)
+		}
+
+		names = append(names, h.Name)
+
+		if strings.HasSuffix(h.Name, ".idx") {
+			if _, err := idx.ReadFrom(tr); err != nil {
+				t.Fatalf("unable to read the index: %v", err)
+			}
+		}
+	}
+
+	want := []string{
+		"00000000-0000-0001-a000-000000000002",
+		"00000000-0000-0003-b000-000000000004",
+		"80000000-0000-0005-b000-000000000006",
+		"data00000a.tar.brf",
+		"data00000a.tar.gph",
+		"data00000a.tar.idx",
+	}
+
+	if len(names) != len(want) {
+		t.Fatalf("unexpected entries %v", names)
+	}
+
+	for i, name := range names {
+		if !strings.HasPrefix(name, want[i]) {
+			t.Fatalf("unexpected entry %s, want %s", name, want[i])
+		}
+	}
+
+	if len(idx.Entries) != len(segments) {
+		t.Fatalf("unexpected number of index entries %d", len(idx.Entries))
+	}
+
+	for _, e := range idx.Entries {
+		var s *testSegment
+
+		for i := range segments {
+			if segments[i].msb == e.Msb && segments[i].lsb == e.Lsb {
+				s = &segments[i]
+			}
+		}
+
+		if s == nil {
+			t.Fatalf("unexpected index entry %+v", e)
+		}
+
+		if e.Size != len(s.data) {
+			t.Fatalf("unexpected size of %s: got %d, want %d", uuid(e.Msb, e.Lsb), e.Size, len(s.data))
+		}
+
+		if !bytes.Equal(data[e.Position:e.Position+e.Size], s.data) {
+			t.Fatalf("index entry of %s doesn't point to its data", uuid(e.Msb, e.Lsb))
+		}
+
+		header := data[e.Position-blockSize:]
+
+		if !bytes.HasPrefix(header, []byte(uuid(e.Msb, e.Lsb)+".")) {
+			t.Fatalf("index entry of %s doesn't follow its header", uuid(e.Msb, e.Lsb))
+		}
+	}
+
+	if err := Verify(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unable to verify the archive: %v", err)
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	var (
+		b        bytes.Buffer
+		w        = NewWriter(&b, "data00000a.tar")
+		segments = testSegments(t)
+	)
+
+	// Every segment has a different generation, and the segments are written
+	// in a different order than the one of the index.
+
+	for i, s := range segments {
+		if err := w.WriteSegment(s.msb, s.lsb, i+1, i+1, false, s.data); err != nil {
+			t.Fatalf("unable to write the segment: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close the archive: %v", err)
+	}
+
+	for i, s := range segments {
+		e, ok := w.findEntry(s.msb, s.lsb)
+		if !ok || e.Msb != s.msb || e.Lsb != s.lsb || e.Generation != i+1 {
+			t.Fatalf("unexpected entry for segment %d: %+v", i, e)
+		}
+	}
+
+	if err := w.AddBinaryReference(segments[0].msb, segments[0].lsb, "ref"); err == nil {
+		t.Fatalf("binary reference added to a closed writer")
+	}
+
+	if err := w.WriteSegment(2, 0xb000000000000002, 1, 1, false, []byte{1}); err == nil {
+		t.Fatalf("segment written to a closed writer")
+	}
+}
+
+func TestVerifyPosition(t *testing.T) {
+	data := writeArchive(t, testSegments(t), func(w *Writer) {
+		w.index.Entries[1].Position += blockSize
+	})
+
+	if err := Verify(bytes.NewReader(data)); err == nil {
+		t.Fatalf("wrong position not detected")
+	}
+}
+
+func TestVerifySize(t *testing.T) {
+	data := writeArchive(t, testSegments(t), func(w *Writer) {
+		w.index.Entries[0].Size--
+	})
+
+	if err := Verify(bytes.NewReader(data)); err == nil {
+		t.Fatalf("wrong size not detected")
+	}
+}
+
+func TestVerifyMissingEntry(t *testing.T) {
+	data := writeArchive(t, testSegments(t), func(w *Writer) {
+		w.index.Entries = w.index.Entries[:2]
+	})
+
+	if err := Verify(bytes.NewReader(data)); err == nil {
+		t.Fatalf("missing index entry not detected")
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	data := writeArchive(t, testSegments(t), nil)
+
+	if err := Verify(bytes.NewReader(data[:3*blockSize])); err == nil {
+		t.Fatalf("truncated archive not detected")
+	}
+}
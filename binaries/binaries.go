@@ -11,6 +11,7 @@ import (
 // Binaries is the set of binary references in a TAR files grouped by generation
 // and segment.
 type Binaries struct {
+	Version     int
 	Generations []Generation
 }
 
@@ -55,11 +56,100 @@ const (
 	magicV2 = 0x0a31420a
 )
 
+// The versions of the serialization format of the binary references.
+const (
+	V1 = 1
+	V2 = 2
+)
+
+// WriteTo writes the binary references to 'w', using the serialization format
+// specified by the version of 'binaries'. If the version is zero, the latest
+// version is used. Returns the number of bytes written and an optional error.
+func (binaries *Binaries) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+
+	switch binaries.Version {
+	case V1:
+		binaries.writeV1To(&b)
+	case 0, V2:
+		binaries.writeV2To(&b)
+	default:
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, binaries.Version)
+	}
+
+	return b.WriteTo(w)
+}
+
+func (binaries *Binaries) writeV1To(b *bytes.Buffer) {
+	var entries bytes.Buffer
+
+	for _, g := range binaries.Generations {
+		writeInt(&entries, g.Generation)
+		writeSegments(&entries, g.Segments)
+	}
+
+	writeFooter(b, entries.Bytes(), len(binaries.Generations), magicV1)
+}
+
+func (binaries *Binaries) writeV2To(b *bytes.Buffer) {
+	var entries bytes.Buffer
+
+	for _, g := range binaries.Generations {
+		writeInt(&entries, g.Generation)
+		writeInt(&entries, g.FullGeneration)
+		if g.Compacted {
+			entries.WriteByte(1)
+		} else {
+			entries.WriteByte(0)
+		}
+		writeSegments(&entries, g.Segments)
+	}
+
+	writeFooter(b, entries.Bytes(), len(binaries.Generations), magicV2)
+}
+
+func writeSegments(b *bytes.Buffer, segments []Segment) {
+	writeInt(b, len(segments))
+
+	for _, s := range segments {
+		writeLong(b, s.Msb)
+		writeLong(b, s.Lsb)
+		writeInt(b, len(s.References))
+
+		for _, r := range s.References {
+			writeInt(b, len(r))
+			b.WriteString(r)
+		}
+	}
+}
+
+func writeFooter(b *bytes.Buffer, entries []byte, count int, magic uint32) {
+	const binariesFooterSize = 16
+
+	b.Write(entries)
+	writeInt(b, int(crc32.ChecksumIEEE(entries)))
+	writeInt(b, count)
+	writeInt(b, len(entries)+binariesFooterSize)
+	writeInt(b, int(magic))
+}
+
+func writeInt(b *bytes.Buffer, v int) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(v))
+	b.Write(data[:])
+}
+
+func writeLong(b *bytes.Buffer, v uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], v)
+	b.Write(data[:])
+}
+
 func (binaries *Binaries) parseFrom(data []byte) error {
 	n := len(data)
 
 	if n < 4 {
-		return fmt.Errorf("invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: 4, Actual: int64(n)}
 	}
 
 	magic := int(binary.BigEndian.Uint32(data[n-4:]))
@@ -71,7 +161,7 @@ func (binaries *Binaries) parseFrom(data []byte) error {
 		return binaries.parseV2From(data)
 	}
 
-	return fmt.Errorf("unrecognized magic %08x", magic)
+	return &ParseError{Err: ErrMagic, Offset: n - 4, Expected: magicV2, Actual: int64(magic)}
 }
 
 func (binaries *Binaries) parseV1From(data []byte) error {
@@ -79,8 +169,6 @@ func (binaries *Binaries) parseV1From(data []byte) error {
 		binariesMagic          = magicV1
 		binariesFooterSize     = 16
 		binariesGenerationSize = 8
-		binariesSegmentSize    = 20
-		binariesReferenceSize  = 4
 	)
 
 	const (
@@ -93,7 +181,7 @@ func (binaries *Binaries) parseV1From(data []byte) error {
 	n := len(data)
 
 	if n < binariesFooterSize {
-		return fmt.Errorf("Invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: binariesFooterSize, Actual: int64(n)}
 	}
 
 	var (
@@ -105,56 +193,40 @@ func (binaries *Binaries) parseV1From(data []byte) error {
 	)
 
 	if magic != binariesMagic {
-		return fmt.Errorf("Invalid magic")
+		return &ParseError{Err: ErrMagic, Offset: n - binariesFooterSize + binariesFooterMagicOffset, Expected: binariesMagic, Actual: int64(magic)}
 	}
 
 	if size < binariesFooterSize {
-		return fmt.Errorf("Invalid size")
+		return &ParseError{Err: ErrCorrupt, Offset: n - binariesFooterSize + binariesFooterSizeOffset, Expected: binariesFooterSize, Actual: int64(size)}
 	}
 
-	if count < 0 {
-		return fmt.Errorf("Invalid count")
+	if size > n {
+		return &ParseError{Err: ErrTruncated, Offset: n - binariesFooterSize + binariesFooterSizeOffset, Expected: int64(size), Actual: int64(n)}
 	}
 
 	entries := data[n-size : n-binariesFooterSize]
 
-	if int(crc32.ChecksumIEEE(entries)) != checksum {
-		return fmt.Errorf("Invalid checksum")
+	if actual := crc32.ChecksumIEEE(entries); int(actual) != checksum {
+		return &ParseError{Err: ErrChecksum, Offset: n - binariesFooterSize + binariesFooterChecksumOffset, Expected: int64(checksum), Actual: int64(actual)}
+	}
+
+	if max := len(entries) / binariesGenerationSize; count > max {
+		return &ParseError{Err: ErrCorrupt, Offset: n - binariesFooterSize + binariesFooterCountOffset, Expected: int64(max), Actual: int64(count)}
 	}
 
+	binaries.Version = V1
 	binaries.Generations = nil
 
-	buffer := bytes.NewBuffer(entries)
+	r := &reader{data: data[:n-binariesFooterSize], offset: n - size}
 
 	for i := 0; i < count; i++ {
 		var (
-			generation    = int(binary.BigEndian.Uint32(buffer.Next(4)))
-			segmentsCount = int(binary.BigEndian.Uint32(buffer.Next(4)))
-			segments      = make([]Segment, segmentsCount)
+			generation, err1 = r.readInt()
+			segments, err2   = r.readSegments()
 		)
 
-		for i := 0; i < segmentsCount; i++ {
-			var (
-				msb             = binary.BigEndian.Uint64(buffer.Next(8))
-				lsb             = binary.BigEndian.Uint64(buffer.Next(8))
-				referencesCount = int(binary.BigEndian.Uint32(buffer.Next(4)))
-				references      = make([]string, referencesCount)
-			)
-
-			for i := 0; i < referencesCount; i++ {
-				var (
-					size      = int(binary.BigEndian.Uint32(buffer.Next(4)))
-					reference = string(buffer.Next(size))
-				)
-
-				references[i] = reference
-			}
-
-			segments = append(segments, Segment{
-				Msb:        msb,
-				Lsb:        lsb,
-				References: references,
-			})
+		if err := firstError(err1, err2); err != nil {
+			return fmt.Errorf("unable to read generation: %w", err)
 		}
 
 		binaries.Generations = append(binaries.Generations, Generation{
@@ -172,9 +244,7 @@ func (binaries *Binaries) parseV2From(data []byte) error {
 	const (
 		binariesMagic          = magicV2
 		binariesFooterSize     = 16
-		binariesGenerationSize = 8
-		binariesSegmentSize    = 20
-		binariesReferenceSize  = 4
+		binariesGenerationSize = 13
 	)
 
 	const (
@@ -187,7 +257,7 @@ func (binaries *Binaries) parseV2From(data []byte) error {
 	n := len(data)
 
 	if n < binariesFooterSize {
-		return fmt.Errorf("Invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: binariesFooterSize, Actual: int64(n)}
 	}
 
 	var (
@@ -199,67 +269,124 @@ func (binaries *Binaries) parseV2From(data []byte) error {
 	)
 
 	if magic != binariesMagic {
-		return fmt.Errorf("Invalid magic")
+		return &ParseError{Err: ErrMagic, Offset: n - binariesFooterSize + binariesFooterMagicOffset, Expected: binariesMagic, Actual: int64(magic)}
 	}
 
 	if size < binariesFooterSize {
-		return fmt.Errorf("Invalid size")
+		return &ParseError{Err: ErrCorrupt, Offset: n - binariesFooterSize + binariesFooterSizeOffset, Expected: binariesFooterSize, Actual: int64(size)}
 	}
 
-	if count < 0 {
-		return fmt.Errorf("Invalid count")
+	if size > n {
+		return &ParseError{Err: ErrTruncated, Offset: n - binariesFooterSize + binariesFooterSizeOffset, Expected: int64(size), Actual: int64(n)}
 	}
 
 	entries := data[n-size : n-binariesFooterSize]
 
-	if int(crc32.ChecksumIEEE(entries)) != checksum {
-		return fmt.Errorf("Invalid checksum")
+	if actual := crc32.ChecksumIEEE(entries); int(actual) != checksum {
+		return &ParseError{Err: ErrChecksum, Offset: n - binariesFooterSize + binariesFooterChecksumOffset, Expected: int64(checksum), Actual: int64(actual)}
 	}
 
+	if max := len(entries) / binariesGenerationSize; count > max {
+		return &ParseError{Err: ErrCorrupt, Offset: n - binariesFooterSize + binariesFooterCountOffset, Expected: int64(max), Actual: int64(count)}
+	}
+
+	binaries.Version = V2
 	binaries.Generations = make([]Generation, count)
 
-	buffer := bytes.NewBuffer(entries)
+	r := &reader{data: data[:n-binariesFooterSize], offset: n - size}
 
 	for i := 0; i < count; i++ {
 		var (
-			generation     = int(binary.BigEndian.Uint32(buffer.Next(4)))
-			fullGeneration = int(binary.BigEndian.Uint32(buffer.Next(4)))
-			compacted      = buffer.Next(1)[0] != 0
-			segmentsCount  = int(binary.BigEndian.Uint32(buffer.Next(4)))
-			segments       = make([]Segment, segmentsCount)
+			generation, err1     = r.readInt()
+			fullGeneration, err2 = r.readInt()
+			compacted, err3      = r.readByte()
+			segments, err4       = r.readSegments()
 		)
 
-		for i := 0; i < segmentsCount; i++ {
-			var (
-				msb             = binary.BigEndian.Uint64(buffer.Next(8))
-				lsb             = binary.BigEndian.Uint64(buffer.Next(8))
-				referencesCount = int(binary.BigEndian.Uint32(buffer.Next(4)))
-				references      = make([]string, referencesCount)
-			)
-
-			for i := 0; i < referencesCount; i++ {
-				var (
-					size      = int(binary.BigEndian.Uint32(buffer.Next(4)))
-					reference = string(buffer.Next(size))
-				)
-
-				references[i] = reference
-			}
-
-			segments[i] = Segment{
-				Msb:        msb,
-				Lsb:        lsb,
-				References: references,
-			}
+		if err := firstError(err1, err2, err3, err4); err != nil {
+			return fmt.Errorf("unable to read generation: %w", err)
 		}
 
 		binaries.Generations[i] = Generation{
 			Generation:     generation,
 			FullGeneration: fullGeneration,
-			Compacted:      compacted,
+			Compacted:      compacted != 0,
 			Segments:       segments,
 		}
 	}
 
 	return nil
 }
+
+// readSegments reads a list of segments and their binary references. The
+// number of elements in every list is checked against the remaining data
+// before allocating memory for it.
+func (r *reader) readSegments() ([]Segment, error) {
+	const (
+		segmentSize   = 20
+		referenceSize = 4
+	)
+
+	offset := r.offset
+
+	count, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	if max := r.remaining() / segmentSize; count > max {
+		return nil, &ParseError{Err: ErrCorrupt, Offset: offset, Expected: int64(max), Actual: int64(count)}
+	}
+
+	segments := make([]Segment, count)
+
+	for i := range segments {
+		var (
+			msb, err1   = r.readLong()
+			lsb, err2   = r.readLong()
+			offset      = r.offset
+			count, err3 = r.readInt()
+		)
+
+		if err := firstError(err1, err2, err3); err != nil {
+			return nil, err
+		}
+
+		if max := r.remaining() / referenceSize; count > max {
+			return nil, &ParseError{Err: ErrCorrupt, Offset: offset, Expected: int64(max), Actual: int64(count)}
+		}
+
+		references := make([]string, count)
+
+		for i := range references {
+			size, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := r.next(size)
+			if err != nil {
+				return nil, err
+			}
+
+			references[i] = string(data)
+		}
+
+		segments[i] = Segment{
+			Msb:        msb,
+			Lsb:        lsb,
+			References: references,
+		}
+	}
+
+	return segments, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package binaries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func testGenerations(compacted bool) []Generation {
+	return []Generation{
+		{
+			Generation:     1,
+			FullGeneration: 1,
+			Compacted:      compacted,
+			Segments: []Segment{
+				{Msb: 1, Lsb: 2, References: []string{"a#1", "b#2"}},
+				{Msb: 3, Lsb: 4, References: []string{"c#3"}},
+			},
+		},
+		{
+			Generation:     2,
+			FullGeneration: 2,
+			Compacted:      true,
+			Segments: []Segment{
+				{Msb: 5, Lsb: 6, References: []string{"d#4"}},
+			},
+		},
+	}
+}
+
+func roundTrip(t *testing.T, binaries *Binaries) *Binaries {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	if _, err := binaries.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the binary references: %v", err)
+	}
+
+	var r Binaries
+
+	if _, err := r.ReadFrom(&b); err != nil {
+		t.Fatalf("unable to read the binary references: %v", err)
+	}
+
+	return &r
+}
+
+func TestWriteToReadFromV1(t *testing.T) {
+	binaries := &Binaries{Version: V1, Generations: testGenerations(true)}
+
+	if r := roundTrip(t, binaries); !reflect.DeepEqual(r, binaries) {
+		t.Fatalf("binary references don't match: got %+v, want %+v", r, binaries)
+	}
+}
+
+func TestWriteToReadFromV2(t *testing.T) {
+	binaries := &Binaries{Version: V2, Generations: testGenerations(false)}
+
+	if r := roundTrip(t, binaries); !reflect.DeepEqual(r, binaries) {
+		t.Fatalf("binary references don't match: got %+v, want %+v", r, binaries)
+	}
+}
+
+func TestWriteToDefaultVersion(t *testing.T) {
+	r := roundTrip(t, &Binaries{Generations: testGenerations(false)})
+
+	if r.Version != V2 {
+		t.Fatalf("unexpected version %d", r.Version)
+	}
+
+	if want := testGenerations(false); !reflect.DeepEqual(r.Generations, want) {
+		t.Fatalf("generations don't match: got %+v, want %+v", r.Generations, want)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, version := range []int{V1, V2} {
+		var b bytes.Buffer
+		(&Binaries{Version: version, Generations: testGenerations(true)}).WriteTo(&b)
+		f.Add(b.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = withChecksum(data)
+
+		var binaries Binaries
+
+		if err := binaries.parseFrom(data); err != nil {
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			return
+		}
+
+		if r := roundTrip(t, &binaries); !reflect.DeepEqual(r, &binaries) {
+			t.Fatalf("binary references don't match: got %+v, want %+v", r, &binaries)
+		}
+	})
+}
+
+// withChecksum returns a copy of 'data' where the checksum in the footer
+// matches the entries, so that the fuzzer can reach the parsing of the
+// entries. Data whose footer doesn't delimit the entries is returned as is.
+func withChecksum(data []byte) []byte {
+	const footerSize = 16
+
+	n := len(data)
+
+	if n < footerSize {
+		return data
+	}
+
+	size := int(binary.BigEndian.Uint32(data[n-footerSize+8:]))
+
+	if size < footerSize || size > n {
+		return data
+	}
+
+	fixed := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(fixed[n-footerSize:], crc32.ChecksumIEEE(fixed[n-size:n-footerSize]))
+
+	return fixed
+}
+
+func TestParseErrors(t *testing.T) {
+	var b bytes.Buffer
+	(&Binaries{Generations: testGenerations(false)}).WriteTo(&b)
+	valid := b.Bytes()
+
+	n := len(valid)
+
+	corrupt := func(f func(data []byte)) []byte {
+		data := append([]byte(nil), valid...)
+		f(data)
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		offset int
+	}{
+		{"footer", valid[n-8:], ErrTruncated, 0},
+		{"entries", valid[1:], ErrTruncated, n - 1 - 8},
+		{"magic", corrupt(func(data []byte) { data[n-1] = 0 }), ErrMagic, n - 4},
+		{"checksum", corrupt(func(data []byte) { data[0] ^= 0xff }), ErrChecksum, n - 16},
+		{"size", corrupt(func(data []byte) { binary.BigEndian.PutUint32(data[n-8:], 0) }), ErrCorrupt, n - 8},
+	}
+
+	for _, test := range tests {
+		var binaries Binaries
+
+		_, err := binaries.ReadFrom(bytes.NewReader(test.data))
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: unexpected error %v, want %v", test.name, err, test.err)
+		}
+
+		var pe *ParseError
+
+		if !errors.As(err, &pe) {
+			t.Fatalf("%s: unexpected error type %T", test.name, err)
+		}
+
+		if pe.Offset != test.offset {
+			t.Fatalf("%s: unexpected offset %d, want %d", test.name, pe.Offset, test.offset)
+		}
+	}
+}
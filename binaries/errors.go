@@ -0,0 +1,91 @@
+package binaries
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMagic is returned when the footer doesn't end with the magic number
+	// of one of the supported versions.
+	ErrMagic = errors.New("invalid magic")
+	// ErrChecksum is returned when the checksum in the footer doesn't match
+	// the checksum of the binary references.
+	ErrChecksum = errors.New("invalid checksum")
+	// ErrTruncated is returned when the data ends before the size in the
+	// footer, or a count in one of the entries, says it should.
+	ErrTruncated = errors.New("truncated data")
+	// ErrCorrupt is returned when a size or a count is inconsistent with the
+	// amount of data available.
+	ErrCorrupt = errors.New("corrupt data")
+	// ErrUnsupportedVersion is returned when writing binary references in a
+	// version that is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+// ParseError describes why the binary references couldn't be parsed. Err is
+// one of the errors defined by this package and can be tested with errors.Is.
+// Offset is the position in the data of the field that failed validation.
+// Expected and Actual are the expected and actual values of the field. For
+// ErrTruncated they are the required and available number of bytes, and for
+// ErrCorrupt they are the maximum and actual value of a size or a count.
+type ParseError struct {
+	Err      error
+	Offset   int
+	Expected int64
+	Actual   int64
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d: expected %d, actual %d", e.Err, e.Offset, e.Expected, e.Actual)
+}
+
+// Unwrap returns Err.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// reader reads the entries of the binary references and keeps track of the
+// offset of the next read, so that errors can point to the truncated field.
+type reader struct {
+	data   []byte
+	offset int
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if r.remaining() < n {
+		return nil, &ParseError{Err: ErrTruncated, Offset: r.offset, Expected: int64(n), Actual: int64(r.remaining())}
+	}
+	data := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return data, nil
+}
+
+func (r *reader) readByte() (byte, error) {
+	data, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+func (r *reader) readInt() (int, error) {
+	data, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(data)), nil
+}
+
+func (r *reader) readLong() (uint64, error) {
+	data, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
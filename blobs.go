@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"./binaries"
+	"./store"
+)
+
+// blob is an external binary referenced by the binary references indexes of a
+// store. The length is the one recorded in the blob ID, or -1 if the blob ID
+// doesn't record it.
+type blob struct {
+	id          string
+	length      int64
+	segments    map[store.SegmentID]bool
+	generations map[int]bool
+}
+
+// readBlobs returns the blobs referenced by the binary references indexes of
+// the active TAR files of 's'. Every blob is returned once, in the order it is
+// first found.
+func readBlobs(s *store.Store) ([]*blob, error) {
+	var (
+		blobs []*blob
+		byID  = make(map[string]*blob)
+	)
+
+	for _, t := range s.Tars {
+		var bns binaries.Binaries
+
+		if _, err := t.ReadBinaries(&bns); err != nil {
+			return nil, fmt.Errorf("unable to read the binary references of %s: %v", t.Name, err)
+		}
+
+		for _, g := range bns.Generations {
+			for _, sg := range g.Segments {
+				for _, id := range sg.References {
+					b := byID[id]
+					if b == nil {
+						b = &blob{
+							id:          id,
+							length:      declaredBlobLength(id),
+							segments:    make(map[store.SegmentID]bool),
+							generations: make(map[int]bool),
+						}
+						byID[id] = b
+						blobs = append(blobs, b)
+					}
+					b.segments[store.SegmentID{Msb: sg.Msb, Lsb: sg.Lsb}] = true
+					b.generations[g.Generation] = true
+				}
+			}
+		}
+	}
+
+	return blobs, nil
+}
+
+// declaredBlobLength returns the length recorded in a blob ID after the last
+// '#', or -1 if the blob ID doesn't record a length.
+func declaredBlobLength(blobID string) int64 {
+	i := strings.LastIndex(blobID, "#")
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(blobID[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// printBlobs prints every blob referenced by the active TAR files in
+// 'directory', followed by the number of blobs and their total length. If
+// 'summary' is true, only the totals are printed.
+func printBlobs(directory string, summary bool, w io.Writer) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := readBlobs(s)
+	if err != nil {
+		return err
+	}
+
+	var (
+		total   int64
+		unknown int
+	)
+
+	for _, b := range blobs {
+		if b.length < 0 {
+			unknown++
+		} else {
+			total += b.length
+		}
+		if !summary {
+			fmt.Fprintf(w, "blob %s %d %d %d\n", b.id, b.length, len(b.segments), len(b.generations))
+		}
+	}
+
+	fmt.Fprintf(w, "blobs %d\n", len(blobs))
+	fmt.Fprintf(w, "bytes %d\n", total)
+	fmt.Fprintf(w, "unknown %d\n", unknown)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"./store"
+)
+
+// blobIdentifierRegexp matches the content identifier of a blob ID, which is
+// the name of the file containing the blob in a FileDataStore.
+var blobIdentifierRegexp = regexp.MustCompile("^[0-9a-f]{6,}$")
+
+// printBlobsCheck checks the blobs referenced by the active TAR files in
+// 'directory' against the FileDataStore in 'datastore'. It prints the blobs
+// that are missing or have the wrong length, the files of the data store that
+// are not referenced, and a summary.
+func printBlobsCheck(directory, datastore string, w io.Writer) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := readBlobs(s)
+	if err != nil {
+		return err
+	}
+
+	var (
+		referenced = make(map[string]bool)
+		missing    int
+		wrong      int
+		invalid    int
+		orphaned   int
+	)
+
+	for _, b := range blobs {
+		blobID := b.id
+		identifier := blobIdentifier(blobID)
+
+		if !blobIdentifierRegexp.MatchString(identifier) {
+			fmt.Fprintf(w, "invalid %s\n", blobID)
+			invalid++
+			continue
+		}
+
+		p := fileDataStorePath(datastore, identifier)
+
+		referenced[p] = true
+
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(w, "missing %s %s\n", blobID, p)
+			missing++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if b.length >= 0 && info.Size() != b.length {
+			fmt.Fprintf(w, "length %s %s %d %d\n", blobID, p, b.length, info.Size())
+			wrong++
+		}
+	}
+
+	err = filepath.Walk(datastore, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if !isFileDataStorePath(datastore, p) {
+			return nil
+		}
+		if !referenced[p] {
+			fmt.Fprintf(w, "orphaned %s %d\n", p, info.Size())
+			orphaned++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the data store: %v", err)
+	}
+
+	fmt.Fprintf(w, "checked %d missing %d length %d invalid %d orphaned %d\n", len(blobs), missing, wrong, invalid, orphaned)
+
+	return nil
+}
+
+// blobIdentifier returns the content identifier of a blob ID, which is the part
+// of the blob ID before the length.
+func blobIdentifier(blobID string) string {
+	if i := strings.LastIndex(blobID, "#"); i >= 0 {
+		return blobID[:i]
+	}
+	return blobID
+}
+
+// fileDataStorePath returns the path of the file containing the blob
+// 'identifier' in the FileDataStore 'datastore'. Files are stored in three
+// levels of directories, named after the first three pairs of hex digits of
+// the identifier.
+func fileDataStorePath(datastore, identifier string) string {
+	return filepath.Join(datastore, identifier[0:2], identifier[2:4], identifier[4:6], identifier)
+}
+
+// isFileDataStorePath returns true if 'p' is the path of a blob in the
+// FileDataStore 'datastore'. Other files, like temporary files, are ignored.
+func isFileDataStorePath(datastore, p string) bool {
+	name := filepath.Base(p)
+	if !blobIdentifierRegexp.MatchString(name) {
+		return false
+	}
+	return filepath.Clean(p) == fileDataStorePath(datastore, name)
+}
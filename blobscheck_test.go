@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"./record"
+)
+
+func TestBlobsCheck(t *testing.T) {
+	var (
+		directory = t.TempDir()
+		datastore = t.TempDir()
+	)
+
+	const (
+		present  = "0123456789abcdef"
+		missing  = "fedcba9876543210"
+		length   = "aabbccddeeff0011"
+		orphaned = "99887766554433"
+	)
+
+	var values []record.Value
+
+	for _, blobID := range []string{present + "#5", missing + "#3", length + "#4", "not-an-identifier#1"} {
+		values = append(values, record.Value{BlobID: blobID})
+	}
+
+	writeTestStore(t, directory, 1, &record.Node{
+		Properties: []record.Property{
+			{Name: "blobs", Type: record.TypeBinary, Array: true, Values: values},
+		},
+	})
+
+	files := map[string]string{
+		filepath.Join("01", "23", "45", present):  "12345",
+		filepath.Join("aa", "bb", "cc", length):   "12",
+		filepath.Join("99", "88", "77", orphaned): "123",
+		// Files not following the layout of a FileDataStore are ignored.
+		filepath.Join("tmp", "upload.tmp"):  "1",
+		filepath.Join("00", "00", orphaned): "1",
+	}
+
+	for name, content := range files {
+		p := filepath.Join(datastore, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+
+	if err := printBlobsCheck(directory, datastore, &b); err != nil {
+		t.Fatalf("unable to check the blobs: %v", err)
+	}
+
+	path := func(identifier string) string {
+		return filepath.Join(datastore, identifier[0:2], identifier[2:4], identifier[4:6], identifier)
+	}
+
+	want := []string{
+		fmt.Sprintf("missing %s#3 %s", missing, path(missing)),
+		fmt.Sprintf("length %s#4 %s 4 2", length, path(length)),
+		"invalid not-an-identifier#1",
+		fmt.Sprintf("orphaned %s 3", path(orphaned)),
+		"checked 4 missing 1 length 1 invalid 1 orphaned 1",
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	for _, line := range want {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("no line %q:\n%s", line, b.String())
+		}
+	}
+}
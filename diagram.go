@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+
+	"./graph"
+	"./index"
+)
+
+// A diagram is a graph of segments ready to be printed in a format understood
+// by graph visualization tools. Nodes and edges are printed in the order they
+// are added.
+type diagram struct {
+	nodes []*diagramNode
+	edges []diagramEdge
+	byID  map[string]*diagramNode
+}
+
+// A diagramNode is a segment in a diagram. The type and the generations of the
+// segment are taken from the index of the TAR file the segment belongs to. If
+// the index of the TAR file is not known, 'tar' is empty and the generations
+// are unknown. A segment is missing if it is not in any index.
+type diagramNode struct {
+	id             string
+	tar            string
+	generation     int
+	fullGeneration int
+	missing        bool
+}
+
+// A diagramEdge is a reference between two segments. 'crossTar' is true if the
+// segments are not stored in the same TAR file.
+type diagramEdge struct {
+	from     string
+	to       string
+	crossTar bool
+}
+
+func newDiagram() *diagram {
+	return &diagram{byID: make(map[string]*diagramNode)}
+}
+
+func (d *diagram) addNode(n diagramNode) {
+	if _, ok := d.byID[n.id]; ok {
+		return
+	}
+	d.nodes = append(d.nodes, &n)
+	d.byID[n.id] = &n
+}
+
+func (d *diagram) addEdge(from, to string) {
+	var (
+		f = d.byID[from]
+		t = d.byID[to]
+	)
+	d.edges = append(d.edges, diagramEdge{
+		from:     from,
+		to:       to,
+		crossTar: f == nil || t == nil || f.tar == "" || f.tar != t.tar,
+	})
+}
+
+func (n *diagramNode) label() string {
+	if n.missing {
+		return fmt.Sprintf("%s %s\\nmissing", segmentType(n.id), n.id[:8])
+	}
+	if n.tar == "" {
+		return fmt.Sprintf("%s %s\\nother TAR", segmentType(n.id), n.id[:8])
+	}
+	return fmt.Sprintf("%s %s\\ngeneration %d/%d", segmentType(n.id), n.id[:8], n.generation, n.fullGeneration)
+}
+
+func (e diagramEdge) color() string {
+	if e.crossTar {
+		return "red"
+	}
+	return "black"
+}
+
+// readTarDiagram reads the graph of the TAR file at path 'p'. The nodes of the
+// diagram are the segments mentioned by the graph, labeled with the
+// information from the index of the same TAR file. Segments not in the index
+// are stored in other TAR files.
+func readTarDiagram(p string) (*diagram, error) {
+	var (
+		idx index.Index
+		gph graph.Graph
+	)
+
+	err := onMatchingEntry(p, isIndex, func(_ string, r io.Reader) error {
+		_, err := idx.ReadFrom(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the index: %v", err)
+	}
+
+	err = onMatchingEntry(p, isGraph, func(_ string, r io.Reader) error {
+		_, err := gph.ReadFrom(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the graph: %v", err)
+	}
+
+	var (
+		name    = filepath.Base(p)
+		entries = make(map[string]index.Entry)
+		d       = newDiagram()
+	)
+
+	for _, e := range idx.Entries {
+		entries[segmentID(e.Msb, e.Lsb)] = e
+	}
+
+	node := func(id string) diagramNode {
+		e, ok := entries[id]
+		if !ok {
+			return diagramNode{id: id}
+		}
+		return diagramNode{
+			id:             id,
+			tar:            name,
+			generation:     e.Generation,
+			fullGeneration: e.FullGeneration,
+		}
+	}
+
+	for _, e := range gph.Entries {
+		from := segmentID(e.Msb, e.Lsb)
+		d.addNode(node(from))
+		for _, r := range e.References {
+			to := segmentID(r.Msb, r.Lsb)
+			d.addNode(node(to))
+			d.addEdge(from, to)
+		}
+	}
+
+	return d, nil
+}
+
+func writeDiagram(f format, w io.Writer, d *diagram) error {
+	switch f {
+	case formatDOT:
+		return writeDOT(w, d)
+	case formatGraphML:
+		return writeGraphML(w, d)
+	default:
+		return errInvalidFormat
+	}
+}
+
+// writeDOT prints the diagram in the DOT language of Graphviz. Data segments
+// are ellipses and bulk segments are boxes. Segments from other TAR files are
+// dashed, missing segments are red.
+func writeDOT(w io.Writer, d *diagram) error {
+	fmt.Fprintln(w, "digraph segments {")
+	for _, n := range d.nodes {
+		shape := "ellipse"
+		if isBulkSegmentID(n.id) {
+			shape = "box"
+		}
+		style, color := "solid", "black"
+		if n.tar == "" {
+			style = "dashed"
+		}
+		if n.missing {
+			color = "red"
+		}
+		fmt.Fprintf(w, "  \"%s\" [label=\"%s\", shape=%s, style=%s, color=%s];\n", n.id, n.label(), shape, style, color)
+	}
+	for _, e := range d.edges {
+		fmt.Fprintf(w, "  \"%s\" -> \"%s\" [color=%s];\n", e.from, e.to, e.color())
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// writeGraphML prints the diagram in the GraphML format. The information about
+// segments and references is stored in GraphML attributes.
+func writeGraphML(w io.Writer, d *diagram) error {
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="type" for="node" attr.name="type" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="tar" for="node" attr.name="tar" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="generation" for="node" attr.name="generation" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <key id="fullGeneration" for="node" attr.name="fullGeneration" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <key id="missing" for="node" attr.name="missing" attr.type="boolean"/>`)
+	fmt.Fprintln(w, `  <key id="crossTar" for="edge" attr.name="crossTar" attr.type="boolean"/>`)
+	fmt.Fprintln(w, `  <key id="color" for="edge" attr.name="color" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <graph id="segments" edgedefault="directed">`)
+	for _, n := range d.nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\">\n", n.id)
+		fmt.Fprintf(w, "      <data key=\"type\">%s</data>\n", segmentType(n.id))
+		if n.tar != "" {
+			fmt.Fprintf(w, "      <data key=\"tar\">%s</data>\n", html.EscapeString(n.tar))
+			fmt.Fprintf(w, "      <data key=\"generation\">%d</data>\n", n.generation)
+			fmt.Fprintf(w, "      <data key=\"fullGeneration\">%d</data>\n", n.fullGeneration)
+		}
+		fmt.Fprintf(w, "      <data key=\"missing\">%v</data>\n", n.missing)
+		fmt.Fprintf(w, "    </node>\n")
+	}
+	for _, e := range d.edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\">\n", e.from, e.to)
+		fmt.Fprintf(w, "      <data key=\"crossTar\">%v</data>\n", e.crossTar)
+		fmt.Fprintf(w, "      <data key=\"color\">%s</data>\n", e.color())
+		fmt.Fprintf(w, "    </edge>\n")
+	}
+	fmt.Fprintln(w, `  </graph>`)
+	_, err := fmt.Fprintln(w, `</graphml>`)
+	return err
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"./record"
+	"./segment"
+	"./store"
+)
+
+// duUsage is the space used by the records of a node or of a tree. Records are
+// the bytes of the records in data segments, binaries are the bytes of the
+// blocks in bulk segments, and blobs are the lengths of the external binaries
+// as recorded in their blob IDs. Shared is the size of the records that were
+// already counted for another node, and are not included in the other sizes.
+type duUsage struct {
+	records  int64
+	binaries int64
+	blobs    int64
+	shared   int64
+}
+
+func (u *duUsage) add(o duUsage) {
+	u.records += o.records
+	u.binaries += o.binaries
+	u.blobs += o.blobs
+	u.shared += o.shared
+}
+
+// duCounter attributes the records read from a store to the nodes they belong
+// to. Every record is counted once, at the first node it is found.
+type duCounter struct {
+	reader  *record.Reader
+	counted map[record.ID]bool
+}
+
+// duTree is a tree being walked, and the size of all the records found in it
+// so far, including the shared ones.
+type duTree struct {
+	path string
+	id   record.ID
+	size int64
+}
+
+// printDiskUsage prints the space used by the tree at 'path' in the head
+// revision, and by its subtrees up to 'depth' levels below it. The records of
+// a node are the node record and the records read to read its properties and
+// the names of its children. Paths are relative to the super root. A node
+// record found again at another path is reported as shared at that path, with
+// the size of the records of its tree.
+func printDiskUsage(directory, path string, depth int, w io.Writer) error {
+	if depth < 0 {
+		return fmt.Errorf("invalid depth %d", depth)
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	r := newRecordReader(s)
+
+	path = cleanPath(path)
+
+	id, err := readPath(r, head, path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		c      = duCounter{reader: r, counted: make(map[record.ID]bool)}
+		paths  []string
+		usages = make(map[string]*duUsage)
+
+		// trees are the trees being walked, from the outermost one, and
+		// sizes are the sizes of the trees already walked.
+		trees []*duTree
+		sizes = make(map[record.ID]int64)
+	)
+
+	// enter closes the trees that don't contain 'p', and adds 'size' to the
+	// size of the ones that do.
+	enter := func(p string, size int64) {
+		for len(trees) > 0 && !isAncestorPath(trees[len(trees)-1].path, p) {
+			t := trees[len(trees)-1]
+			sizes[t.id] = t.size
+			trees = trees[:len(trees)-1]
+		}
+		for _, t := range trees {
+			t.size += size
+		}
+	}
+
+	// add adds 'u' to the usage of 'p' and of its ancestors, if they are
+	// printed.
+	add := func(p string, u duUsage) {
+		if duDepth(path, p) <= depth {
+			paths = append(paths, p)
+			usages[p] = &duUsage{}
+		}
+
+		for a := p; ; a = parentPath(a) {
+			if t := usages[a]; t != nil {
+				t.add(u)
+			}
+			if a == path {
+				break
+			}
+		}
+	}
+
+	visit := func(p string, id record.ID, n *record.NodeRecord, err error) error {
+		if err != nil {
+			fmt.Fprintf(w, "unreadable %s %s\n", id, p)
+			return nil
+		}
+
+		u, err := c.nodeUsage(id, n)
+		if err != nil {
+			return fmt.Errorf("unable to read node %s: %v", p, err)
+		}
+
+		size := u.records + u.binaries + u.shared
+
+		enter(p, size)
+		trees = append(trees, &duTree{path: p, id: id, size: size})
+		add(p, u)
+
+		return nil
+	}
+
+	shared := func(p string, id record.ID) error {
+		enter(p, 0)
+
+		size := sizes[id]
+
+		for _, t := range trees {
+			t.size += size
+		}
+
+		add(p, duUsage{shared: size})
+
+		return nil
+	}
+
+	if err := walkSharedTree(r, id, path, visit, shared); err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		u := usages[p]
+		fmt.Fprintf(w, "%d %d %d %s\n", u.records, u.binaries, u.blobs, p)
+		if u.shared > 0 {
+			fmt.Fprintf(w, "shared %d %s\n", u.shared, p)
+		}
+	}
+
+	return nil
+}
+
+// nodeUsage returns the space used by the records of the node 'n', excluding
+// the records already counted for other nodes.
+func (c *duCounter) nodeUsage(id record.ID, n *record.NodeRecord) (duUsage, error) {
+	ids, blobs, err := nodeRecords(c.reader, id, n)
+	if err != nil {
+		return duUsage{}, err
+	}
+
+	var u duUsage
+
+	for _, id := range ids {
+		size, err := c.reader.Size(id)
+		if err != nil {
+			return duUsage{}, err
+		}
+
+		if c.counted[id] {
+			u.shared += int64(size)
+			continue
+		}
+
+		c.counted[id] = true
+
+		t, err := c.reader.Type(id)
+		if err != nil {
+			return duUsage{}, err
+		}
+
+		switch {
+		case isBulkSegmentID(recordSegmentID(id).String()):
+			u.binaries += int64(size)
+		case t == segment.RecordTypeBlobID:
+			u.records += int64(size)
+			u.blobs += blobs[id]
+		default:
+			u.records += int64(size)
+		}
+	}
+
+	return u, nil
+}
+
+// duDepth returns how many levels 'p' is below 'base'.
+func duDepth(base, p string) int {
+	return pathLevel(p) - pathLevel(base)
+}
+
+// pathLevel returns the number of names in the absolute path 'p'.
+func pathLevel(p string) int {
+	if p == "/" {
+		return 0
+	}
+	return strings.Count(p, "/")
+}
+
+// isAncestorPath returns true if 'a' is an ancestor of 'p'.
+func isAncestorPath(a, p string) bool {
+	if a == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, a+"/")
+}
+
+func parentPath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"./record"
+)
+
+// writeTestStore writes a store of 'tars' TAR files to 'directory' whose head
+// revision has 'root' as the root of the content tree.
+func writeTestStore(t *testing.T, directory string, tars int, root *record.Node) {
+	t.Helper()
+
+	var (
+		sw = &storeWriter{}
+		w  = record.NewWriter(rand.New(rand.NewSource(1)), sw.addSegment)
+	)
+
+	w.Time = synthBaseTime
+
+	id, err := w.WriteNode(superRoot(root, &record.Node{}))
+	if err != nil {
+		t.Fatalf("unable to write the tree: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	sw.addRevision(id, synthBaseTime)
+
+	if err := sw.writeTo(directory, tars, synthBaseTime); err != nil {
+		t.Fatalf("unable to write the store: %v", err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        1,
+		tars:        1,
+		generations: 1,
+		depth:       2,
+		fanout:      2,
+		properties:  3,
+		journal:     1,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	var b bytes.Buffer
+
+	if err := printDiskUsage(directory, "/root", 1, &b); err != nil {
+		t.Fatalf("unable to print the disk usage: %v", err)
+	}
+
+	// The nodes have the same primary type, so every node after the first
+	// reuses the records counted for the root.
+
+	for _, p := range []string{"/root", "/root/node0", "/root/node1"} {
+		if !containsLine(b.String(), "shared ", " "+p) {
+			t.Fatalf("no shared records for %s:\n%s", p, b.String())
+		}
+	}
+}
+
+func TestDiskUsageSharedTree(t *testing.T) {
+	directory := t.TempDir()
+
+	// The same subtree is written once, and referenced by two children.
+
+	tree := &record.Node{
+		Properties: []record.Property{
+			{Name: "text", Type: record.TypeString, Values: []record.Value{{String: strings.Repeat("x", 1000)}}},
+		},
+		Children: []record.Child{
+			{Name: "leaf", Node: &record.Node{}},
+		},
+	}
+
+	writeTestStore(t, directory, 1, &record.Node{
+		Children: []record.Child{
+			{Name: "a", Node: tree},
+			{Name: "b", Node: tree},
+		},
+	})
+
+	var b bytes.Buffer
+
+	if err := printDiskUsage(directory, "/root", 1, &b); err != nil {
+		t.Fatalf("unable to print the disk usage: %v", err)
+	}
+
+	var (
+		records, binaries, blobs int64
+		shared                   int64
+	)
+
+	if _, err := fmt.Sscanf(findLine(b.String(), "", " /root/a"), "%d %d %d", &records, &binaries, &blobs); err != nil {
+		t.Fatalf("no usage for /root/a:\n%s", b.String())
+	}
+
+	fmt.Sscanf(findLine(b.String(), "shared ", " /root/a"), "shared %d", &shared)
+
+	if records < 1000 {
+		t.Fatalf("unexpected size of /root/a: %d", records)
+	}
+
+	if line := findLine(b.String(), "", " /root/b"); line != "0 0 0 /root/b" {
+		t.Fatalf("unexpected usage of /root/b: %q", line)
+	}
+
+	want := fmt.Sprintf("shared %d /root/b", records+binaries+shared)
+
+	if line := findLine(b.String(), "shared ", " /root/b"); line != want {
+		t.Fatalf("unexpected shared records of /root/b: got %q, want %q", line, want)
+	}
+}
+
+func containsLine(s, prefix, suffix string) bool {
+	return findLine(s, prefix, suffix) != ""
+}
+
+// findLine returns the first line of 's' with the given prefix and suffix. An
+// empty prefix only matches lines not starting with "shared".
+func findLine(s, prefix, suffix string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if prefix == "" && strings.HasPrefix(line, "shared ") {
+			continue
+		}
+		if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, suffix) {
+			return line
+		}
+	}
+	return ""
+}
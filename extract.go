@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"./record"
+	"./segment"
+	"./store"
+)
+
+// extractBinary writes the value of a property to the file at path 'output',
+// or to the standard output if 'output' is empty. See resolveValue for how the
+// value is specified. Long values are rebuilt from their blocks, which can be
+// stored in more than one segment. The file is created only after the value is
+// found, and is removed if the value can't be read completely.
+func extractBinary(directory, value, output string) error {
+	r, id, err := resolveValue(directory, value)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err := r.WriteValueTo(id, os.Stdout)
+		return err
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.WriteValueTo(id, f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(output)
+		return err
+	}
+
+	return nil
+}
+
+// resolveValue returns a reader for the records of the store in 'directory',
+// and the ID of a value record in it. The value is specified either by the ID
+// of a value record, or by the path of a node in the head revision followed by
+// the name of the property.
+func resolveValue(directory, value string) (*record.Reader, record.ID, error) {
+	s, err := store.Open(directory)
+	if err != nil {
+		return nil, record.ID{}, err
+	}
+
+	r := newRecordReader(s)
+
+	id, err := record.ParseID(value)
+	if err != nil {
+		if id, err = readPropertyValue(s, r, value); err != nil {
+			return nil, record.ID{}, err
+		}
+	}
+
+	t, err := r.Type(id)
+	if err != nil {
+		return nil, record.ID{}, err
+	}
+
+	if t == segment.RecordTypeBlobID {
+		blobID, err := r.ReadBlobID(id)
+		if err != nil {
+			return nil, record.ID{}, err
+		}
+		return nil, record.ID{}, fmt.Errorf("the value is stored in the external blob %s", blobID)
+	}
+
+	if t != segment.RecordTypeValue {
+		return nil, record.ID{}, fmt.Errorf("record %v is a %s record, not a value record", id, recordType(t))
+	}
+
+	return r, id, nil
+}
+
+// readPropertyValue returns the ID of the value record of the property at
+// 'path' in the head revision. The last name in the path is the name of the
+// property, and the property must have a single value.
+func readPropertyValue(s *store.Store, r *record.Reader, path string) (record.ID, error) {
+	path = cleanPath(path)
+
+	i := strings.LastIndex(path, "/")
+
+	if i == len(path)-1 {
+		return record.ID{}, fmt.Errorf("invalid property path %s", path)
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return record.ID{}, fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	id, err := readPath(r, head, path[:i])
+	if err != nil {
+		return record.ID{}, err
+	}
+
+	n, err := r.ReadNode(id)
+	if err != nil {
+		return record.ID{}, err
+	}
+
+	name := path[i+1:]
+
+	for _, p := range n.Properties {
+		if p.Name != name {
+			continue
+		}
+		if len(p.Values) != 1 {
+			return record.ID{}, fmt.Errorf("property %s has %d values", path, len(p.Values))
+		}
+		return p.Values[0], nil
+	}
+
+	return record.ID{}, fmt.Errorf("property %s not found", path)
+}
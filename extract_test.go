@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"./record"
+)
+
+func TestExtractBinary(t *testing.T) {
+	var (
+		directory = t.TempDir()
+		output    = t.TempDir()
+		data      = make([]byte, 50000)
+	)
+
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// The blocks are written before the value record, so they end up in the
+	// first TAR file, and the value record in the second one.
+
+	writeTestStore(t, directory, 2, &record.Node{
+		Children: []record.Child{
+			{Name: "file", Node: &record.Node{
+				Properties: []record.Property{
+					{Name: "data", Type: record.TypeBinary, Values: []record.Value{{Binary: data}}},
+				},
+			}},
+		},
+	})
+
+	_, id, err := resolveValue(directory, "/root/file/data")
+	if err != nil {
+		t.Fatalf("unable to resolve the value: %v", err)
+	}
+
+	// The value is long enough to be stored in more than one block, and is
+	// extracted both by path and by record ID.
+
+	for _, value := range []string{"/root/file/data", id.String()} {
+		path := filepath.Join(output, "data")
+
+		if err := extractBinary(directory, value, path); err != nil {
+			t.Fatalf("unable to extract %s: %v", value, err)
+		}
+
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Fatalf("the content of %s doesn't match", value)
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No file is left behind when the value can't be found.
+
+	for _, test := range []struct{ directory, value string }{
+		{directory, "/root/file/missing"},
+		{directory, "/root/missing/data"},
+		{filepath.Join(directory, "missing"), "/root/file/data"},
+	} {
+		path := filepath.Join(output, "data")
+
+		if err := extractBinary(test.directory, test.value, path); err == nil {
+			t.Fatalf("%s extracted from %s", test.value, test.directory)
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("output file created for %s", test.value)
+		}
+	}
+
+	// The file is removed when the blocks can't be read.
+
+	if err := os.Remove(filepath.Join(directory, "data00000a.tar")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(output, "data")
+
+	if err := extractBinary(directory, id.String(), path); err == nil {
+		t.Fatalf("value extracted without its blocks")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("output file left behind")
+	}
+}
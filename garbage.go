@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"./store"
+)
+
+type garbageOptions struct {
+	retainedGenerations int
+	gcType              string
+}
+
+func (o garbageOptions) validate() error {
+	if o.retainedGenerations < 1 {
+		return fmt.Errorf("at least one generation must be retained")
+	}
+	if o.gcType != "full" && o.gcType != "tail" {
+		return fmt.Errorf("invalid type of compaction '%s'", o.gcType)
+	}
+	return nil
+}
+
+// garbageUsage accumulates the size and the number of segments, and how much of
+// them can be reclaimed.
+type garbageUsage struct {
+	size                int
+	segments            int
+	reclaimableSize     int
+	reclaimableSegments int
+}
+
+func (u *garbageUsage) add(size int, reclaimable bool) {
+	u.size += size
+	u.segments++
+	if reclaimable {
+		u.reclaimableSize += size
+		u.reclaimableSegments++
+	}
+}
+
+type garbageGeneration struct {
+	generation     int
+	fullGeneration int
+}
+
+// printGarbage prints an estimate of the space that cleanup can reclaim from
+// the store in 'directory'. A segment is retained if it is reachable from the
+// head or from a checkpoint, if its generation is retained, or if it is
+// referenced by a retained segment. The generation of a segment is retained
+// following the same rules used by the cleanup of Oak, where the reference
+// generation is the generation of the segment of the head. Every other segment
+// can be reclaimed.
+func printGarbage(directory string, o garbageOptions, w io.Writer) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		return err
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	roots, err := readRoots(newRecordReader(s), head)
+	if err != nil {
+		return err
+	}
+
+	reference := g.Segment(recordSegmentID(head))
+
+	if reference == nil || reference.Missing {
+		return fmt.Errorf("the segment of the head is missing")
+	}
+
+	var (
+		retained = make(map[*store.GraphSegment]bool)
+		queue    []*store.GraphSegment
+	)
+
+	retain := func(s *store.GraphSegment) {
+		if s != nil && !retained[s] {
+			retained[s] = true
+			queue = append(queue, s)
+		}
+	}
+
+	for _, r := range roots {
+		retain(g.Segment(recordSegmentID(r.id)))
+	}
+
+	for _, s := range g.Segments {
+		if !s.Missing && !isOldGeneration(s, reference, o) {
+			retain(s)
+		}
+	}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, r := range s.References {
+			retain(r)
+		}
+	}
+
+	var (
+		total       garbageUsage
+		tars        = make(map[string]*garbageUsage)
+		generations = make(map[garbageGeneration]*garbageUsage)
+	)
+
+	for _, t := range s.Tars {
+		tars[t.Name] = &garbageUsage{}
+	}
+
+	for _, t := range s.Tars {
+		for _, e := range t.Index.Entries {
+			var (
+				id          = store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}
+				reclaimable = !retained[g.Segment(id)]
+				key         = garbageGeneration{e.Generation, e.FullGeneration}
+			)
+
+			if s.Tar(id) != t {
+				// The segment is a duplicate, and only the copy in the first
+				// TAR file is considered.
+				continue
+			}
+
+			if generations[key] == nil {
+				generations[key] = &garbageUsage{}
+			}
+
+			total.add(e.Size, reclaimable)
+			tars[t.Name].add(e.Size, reclaimable)
+			generations[key].add(e.Size, reclaimable)
+		}
+	}
+
+	for _, t := range s.Tars {
+		u := tars[t.Name]
+		fmt.Fprintf(w, "tar %s %d %d %d %d\n", t.Name, u.size, u.reclaimableSize, u.segments, u.reclaimableSegments)
+	}
+
+	var keys []garbageGeneration
+
+	for k := range generations {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].generation != keys[j].generation {
+			return keys[i].generation < keys[j].generation
+		}
+		return keys[i].fullGeneration < keys[j].fullGeneration
+	})
+
+	for _, k := range keys {
+		u := generations[k]
+		fmt.Fprintf(w, "generation %d %d %d %d %d %d\n", k.generation, k.fullGeneration, u.size, u.reclaimableSize, u.segments, u.reclaimableSegments)
+	}
+
+	fmt.Fprintf(w, "total %d %d %d %d\n", total.size, total.reclaimableSize, total.segments, total.reclaimableSegments)
+
+	return nil
+}
+
+// isOldGeneration returns true if the generation of 's' can be reclaimed with
+// respect to the generation of 'reference'. After a full compaction, segments
+// whose full generation is older than the retained generations are reclaimed.
+// After a tail compaction, segments whose generation is older than the retained
+// generations are reclaimed, unless they were created by a full compaction that
+// is still retained.
+func isOldGeneration(s, reference *store.GraphSegment, o garbageOptions) bool {
+	var (
+		isOld     = reference.Generation-s.Generation >= o.retainedGenerations
+		isOldFull = reference.FullGeneration-s.FullGeneration >= o.retainedGenerations
+	)
+
+	if o.gcType == "full" {
+		return isOldFull
+	}
+
+	if s.Compacted && !isOldFull {
+		return false
+	}
+
+	return isOld
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"./archive"
+	"./record"
+)
+
+// storeWriter collects the segments and the revisions produced by a
+// record.Writer and writes them as a segment store.
+type storeWriter struct {
+	segments  []*record.Segment
+	revisions []revision
+}
+
+type revision struct {
+	id   record.ID
+	time time.Time
+}
+
+func (sw *storeWriter) addSegment(s *record.Segment) error {
+	sw.segments = append(sw.segments, s)
+	return nil
+}
+
+func (sw *storeWriter) addRevision(id record.ID, t time.Time) {
+	sw.revisions = append(sw.revisions, revision{id, t})
+}
+
+// writeTo writes the segments to 'n' TAR files in 'directory', in the order
+// they were added, together with the journal and the manifest. The directory
+// is created if it doesn't exist, and it must be empty otherwise.
+func (sw *storeWriter) writeTo(directory string, n int, modTime time.Time) error {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+
+	if len(infos) > 0 {
+		return fmt.Errorf("directory '%s' is not empty", directory)
+	}
+
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("data%05da.tar", i)
+		segments := sw.segments[i*len(sw.segments)/n : (i+1)*len(sw.segments)/n]
+		if err := writeTarFile(filepath.Join(directory, name), name, segments, modTime); err != nil {
+			return fmt.Errorf("unable to write %s: %v", name, err)
+		}
+	}
+
+	if err := sw.writeJournal(filepath.Join(directory, "journal.log")); err != nil {
+		return fmt.Errorf("unable to write the journal: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(directory, "manifest"), []byte("store.version=2\n"), 0644); err != nil {
+		return fmt.Errorf("unable to write the manifest: %v", err)
+	}
+
+	return nil
+}
+
+func (sw *storeWriter) writeJournal(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	for _, r := range sw.revisions {
+		fmt.Fprintf(w, "%s root %d\n", r.id.Journal(), r.time.UnixNano()/int64(time.Millisecond))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeTarFile(path, name string, segments []*record.Segment, modTime time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	w := archive.NewWriter(bw, name)
+	w.ModTime = modTime
+
+	for _, s := range segments {
+		if err := w.WriteSegment(s.Msb, s.Lsb, s.Generation, s.FullGeneration, s.Compacted, s.Data); err != nil {
+			return err
+		}
+		for _, b := range s.Binaries {
+			if err := w.AddBinaryReference(s.Msb, s.Lsb, b); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return verifyTarFile(path)
+}
+
+// verifyTarFile re-reads a TAR file after it has been written and checks its
+// index against the entries stored in it.
+func verifyTarFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := archive.Verify(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("invalid TAR file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// superRoot returns the node referenced by the journal, whose children are the
+// root of the content tree and the checkpoints.
+func superRoot(root, checkpoints *record.Node) *record.Node {
+	return &record.Node{
+		Children: []record.Child{
+			{Name: "checkpoints", Node: checkpoints},
+			{Name: "root", Node: root},
+		},
+	}
+}
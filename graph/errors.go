@@ -0,0 +1,58 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMagic is returned when the footer of the graph doesn't end with the
+	// expected magic number.
+	ErrMagic = errors.New("invalid magic")
+	// ErrChecksum is returned when the checksum in the footer doesn't match
+	// the checksum of the entries.
+	ErrChecksum = errors.New("invalid checksum")
+	// ErrTruncated is returned when the graph ends before the size in the
+	// footer, or an entry, says it should.
+	ErrTruncated = errors.New("truncated data")
+	// ErrCorrupt is returned when the fields in the footer are inconsistent
+	// with each other.
+	ErrCorrupt = errors.New("corrupt footer")
+)
+
+// ParseError describes why a graph couldn't be parsed. Err is one of the
+// errors defined by this package and can be tested with errors.Is. Offset is
+// the position in the data of the field that failed validation. Expected and
+// Actual are the expected and actual values of that field, except for
+// ErrTruncated, where they are the required and available number of bytes.
+type ParseError struct {
+	Err      error
+	Offset   int
+	Expected int64
+	Actual   int64
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d: expected %d, actual %d", e.Err, e.Offset, e.Expected, e.Actual)
+}
+
+// Unwrap returns Err.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// reader reads the entries of the graph and keeps track of the offset of the
+// next read, so that errors can point to the truncated field.
+type reader struct {
+	data   []byte
+	offset int
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if available := len(r.data) - r.offset; available < n {
+		return nil, &ParseError{Err: ErrTruncated, Offset: r.offset, Expected: int64(n), Actual: int64(available)}
+	}
+	data := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return data, nil
+}
@@ -36,6 +36,18 @@ const (
 // Graph is a graph of references beetween segments.
 type Graph struct {
 	Entries []Entry
+
+	// entries maps the ID of a segment to the position of its entry in
+	// Entries, and to the set of its references. It is built by
+	// AddReferences when 'indexed', the number of entries it covers, doesn't
+	// match Entries.
+	entries map[Reference]*entryIndex
+	indexed int
+}
+
+type entryIndex struct {
+	position   int
+	references map[Reference]bool
 }
 
 // Entry is a collection of a segment and its references towards other segments.
@@ -71,11 +83,79 @@ func (graph *Graph) ReadFrom(r io.Reader) (int64, error) {
 	return n, nil
 }
 
+// WriteTo writes the binary representation of the graph to 'w'. It returns the
+// number of bytes written and an optional error.
+func (graph *Graph) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+
+	for _, entry := range graph.Entries {
+		entry.writeTo(&b)
+	}
+
+	footer := make([]byte, footerSize)
+
+	binary.BigEndian.PutUint32(footer[footerChecksumOffset:], crc32.ChecksumIEEE(b.Bytes()))
+	binary.BigEndian.PutUint32(footer[footerCountOffset:], uint32(len(graph.Entries)))
+	binary.BigEndian.PutUint32(footer[footerSizeOffset:], uint32(b.Len()+footerSize))
+	binary.BigEndian.PutUint32(footer[footerMagicOffset:], graphMagic)
+
+	b.Write(footer)
+
+	return b.WriteTo(w)
+}
+
+// AddReferences adds to the graph the references from the segment identified by
+// 'msb' and 'lsb' towards other segments, usually taken from the reference
+// table of that segment. References from a segment to itself and references
+// already in the graph are ignored. Entries are looked up by segment ID, so
+// that building the graph of a whole TAR file takes linear time. If Entries is
+// modified directly, the lookup is rebuilt on the next call.
+func (graph *Graph) AddReferences(msb, lsb uint64, references []Reference) {
+	if graph.entries == nil || graph.indexed != len(graph.Entries) {
+		graph.indexEntries()
+	}
+
+	id := Reference{Msb: msb, Lsb: lsb}
+	idx := graph.entries[id]
+
+	for _, reference := range references {
+		if reference == id {
+			continue
+		}
+		if idx == nil {
+			idx = &entryIndex{position: len(graph.Entries), references: make(map[Reference]bool)}
+			graph.Entries = append(graph.Entries, Entry{Msb: msb, Lsb: lsb})
+			graph.entries[id] = idx
+			graph.indexed++
+		}
+		if idx.references[reference] {
+			continue
+		}
+		idx.references[reference] = true
+		entry := &graph.Entries[idx.position]
+		entry.References = append(entry.References, reference)
+	}
+}
+
+func (graph *Graph) indexEntries() {
+	graph.entries = make(map[Reference]*entryIndex, len(graph.Entries))
+
+	for i, entry := range graph.Entries {
+		idx := &entryIndex{position: i, references: make(map[Reference]bool, len(entry.References))}
+		for _, r := range entry.References {
+			idx.references[r] = true
+		}
+		graph.entries[Reference{Msb: entry.Msb, Lsb: entry.Lsb}] = idx
+	}
+
+	graph.indexed = len(graph.Entries)
+}
+
 func (graph *Graph) parseFrom(data []byte) error {
 	n := len(data)
 
 	if n < footerSize {
-		return fmt.Errorf("Invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: footerSize, Actual: int64(n)}
 	}
 
 	var (
@@ -87,29 +167,33 @@ func (graph *Graph) parseFrom(data []byte) error {
 	)
 
 	if magic != graphMagic {
-		return fmt.Errorf("Invalid magic")
+		return &ParseError{Err: ErrMagic, Offset: n - footerSize + footerMagicOffset, Expected: graphMagic, Actual: int64(magic)}
 	}
 
 	if size < footerSize {
-		return fmt.Errorf("Invalid size")
+		return &ParseError{Err: ErrCorrupt, Offset: n - footerSize + footerSizeOffset, Expected: footerSize, Actual: int64(size)}
 	}
 
-	if nEntries < 0 {
-		return fmt.Errorf("Invalid entries")
+	if size > n {
+		return &ParseError{Err: ErrTruncated, Offset: n - footerSize + footerSizeOffset, Expected: int64(size), Actual: int64(n)}
 	}
 
 	entries := data[n-size : n-footerSize]
 
-	if int(crc32.ChecksumIEEE(entries)) != checksum {
-		return fmt.Errorf("Invalid checksum")
+	if actual := crc32.ChecksumIEEE(entries); int(actual) != checksum {
+		return &ParseError{Err: ErrChecksum, Offset: n - footerSize + footerChecksumOffset, Expected: int64(checksum), Actual: int64(actual)}
+	}
+
+	if max := len(entries) / keySize; nEntries > max {
+		return &ParseError{Err: ErrCorrupt, Offset: n - footerSize + footerCountOffset, Expected: int64(max), Actual: int64(nEntries)}
 	}
 
-	buffer := bytes.NewBuffer(entries)
+	r := &reader{data: data[:n-footerSize], offset: n - size}
 
 	for i := 0; i < nEntries; i++ {
 		var entry Entry
-		if err := entry.parseFrom(buffer); err != nil {
-			return fmt.Errorf("unable to read entry: %s", err)
+		if err := entry.parseFrom(r); err != nil {
+			return fmt.Errorf("unable to read entry: %w", err)
 		}
 		graph.Entries = append(graph.Entries, entry)
 	}
@@ -117,11 +201,11 @@ func (graph *Graph) parseFrom(data []byte) error {
 	return nil
 }
 
-func (entry *Entry) parseFrom(b *bytes.Buffer) error {
-	data := b.Next(keySize)
+func (entry *Entry) parseFrom(r *reader) error {
+	data, err := r.next(keySize)
 
-	if len(data) < keySize {
-		return fmt.Errorf("not enough data")
+	if err != nil {
+		return err
 	}
 
 	entry.Msb = binary.BigEndian.Uint64(data[entryMsbOffset:])
@@ -131,8 +215,8 @@ func (entry *Entry) parseFrom(b *bytes.Buffer) error {
 
 	for i := 0; i < n; i++ {
 		var reference Reference
-		if err := reference.parseFrom(b); err != nil {
-			return fmt.Errorf("unable to read reference: %s", err)
+		if err := reference.parseFrom(r); err != nil {
+			return fmt.Errorf("unable to read reference: %w", err)
 		}
 		entry.References = append(entry.References, reference)
 	}
@@ -140,11 +224,34 @@ func (entry *Entry) parseFrom(b *bytes.Buffer) error {
 	return nil
 }
 
-func (reference *Reference) parseFrom(b *bytes.Buffer) error {
-	data := b.Next(valueSize)
+func (entry *Entry) writeTo(b *bytes.Buffer) {
+	data := make([]byte, keySize)
+
+	binary.BigEndian.PutUint64(data[entryMsbOffset:], entry.Msb)
+	binary.BigEndian.PutUint64(data[entryLsbOffset:], entry.Lsb)
+	binary.BigEndian.PutUint32(data[entryCountOffset:], uint32(len(entry.References)))
 
-	if len(data) < valueSize {
-		return fmt.Errorf("not enough data")
+	b.Write(data)
+
+	for _, reference := range entry.References {
+		reference.writeTo(b)
+	}
+}
+
+func (reference *Reference) writeTo(b *bytes.Buffer) {
+	data := make([]byte, valueSize)
+
+	binary.BigEndian.PutUint64(data[referenceMsbOffset:], reference.Msb)
+	binary.BigEndian.PutUint64(data[referenceLsbOffset:], reference.Lsb)
+
+	b.Write(data)
+}
+
+func (reference *Reference) parseFrom(r *reader) error {
+	data, err := r.next(valueSize)
+
+	if err != nil {
+		return err
 	}
 
 	reference.Msb = binary.BigEndian.Uint64(data[referenceMsbOffset:])
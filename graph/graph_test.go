@@ -0,0 +1,196 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	var g Graph
+
+	g.AddReferences(1, 2, []Reference{{3, 4}, {5, 6}})
+	g.AddReferences(3, 4, []Reference{{5, 6}})
+	g.AddReferences(7, 8, nil)
+
+	var b bytes.Buffer
+
+	if _, err := g.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the graph: %v", err)
+	}
+
+	var r Graph
+
+	if _, err := r.ReadFrom(&b); err != nil {
+		t.Fatalf("unable to read the graph: %v", err)
+	}
+
+	if !reflect.DeepEqual(r.Entries, g.Entries) {
+		t.Fatalf("entries don't match: got %v, want %v", r.Entries, g.Entries)
+	}
+}
+
+func TestWriteToReadFromEmpty(t *testing.T) {
+	var b bytes.Buffer
+
+	if _, err := (&Graph{}).WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the graph: %v", err)
+	}
+
+	var r Graph
+
+	if _, err := r.ReadFrom(&b); err != nil {
+		t.Fatalf("unable to read the graph: %v", err)
+	}
+
+	if len(r.Entries) != 0 {
+		t.Fatalf("unexpected entries %v", r.Entries)
+	}
+}
+
+func TestAddReferences(t *testing.T) {
+	var g Graph
+
+	g.AddReferences(1, 2, []Reference{{1, 2}, {3, 4}, {3, 4}})
+	g.AddReferences(1, 2, []Reference{{3, 4}, {5, 6}})
+	g.AddReferences(9, 9, []Reference{{9, 9}})
+
+	want := []Entry{
+		{Msb: 1, Lsb: 2, References: []Reference{{3, 4}, {5, 6}}},
+	}
+
+	if !reflect.DeepEqual(g.Entries, want) {
+		t.Fatalf("got %v, want %v", g.Entries, want)
+	}
+}
+
+func TestAddReferencesAfterReadFrom(t *testing.T) {
+	g := Graph{Entries: []Entry{{Msb: 1, Lsb: 2, References: []Reference{{3, 4}}}}}
+
+	g.AddReferences(1, 2, []Reference{{3, 4}, {5, 6}})
+
+	want := []Entry{
+		{Msb: 1, Lsb: 2, References: []Reference{{3, 4}, {5, 6}}},
+	}
+
+	if !reflect.DeepEqual(g.Entries, want) {
+		t.Fatalf("got %v, want %v", g.Entries, want)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	var g Graph
+
+	g.AddReferences(1, 2, []Reference{{3, 4}, {5, 6}})
+
+	var b bytes.Buffer
+	g.WriteTo(&b)
+	f.Add(b.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = withChecksum(data)
+
+		var g Graph
+
+		if err := g.parseFrom(data); err != nil {
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			return
+		}
+
+		var b bytes.Buffer
+
+		if _, err := g.WriteTo(&b); err != nil {
+			t.Fatalf("unable to write the graph: %v", err)
+		}
+
+		var r Graph
+
+		if _, err := r.ReadFrom(&b); err != nil {
+			t.Fatalf("unable to read the graph: %v", err)
+		}
+
+		if !reflect.DeepEqual(r.Entries, g.Entries) {
+			t.Fatalf("entries don't match: got %v, want %v", r.Entries, g.Entries)
+		}
+	})
+}
+
+// withChecksum returns a copy of 'data' where the checksum in the footer
+// matches the entries, so that the fuzzer can reach the parsing of the
+// entries. Data whose footer doesn't delimit the entries is returned as is.
+func withChecksum(data []byte) []byte {
+	const footerSize = 16
+
+	n := len(data)
+
+	if n < footerSize {
+		return data
+	}
+
+	size := int(binary.BigEndian.Uint32(data[n-footerSize+8:]))
+
+	if size < footerSize || size > n {
+		return data
+	}
+
+	fixed := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(fixed[n-footerSize:], crc32.ChecksumIEEE(fixed[n-size:n-footerSize]))
+
+	return fixed
+}
+
+func TestParseErrors(t *testing.T) {
+	var g Graph
+	g.AddReferences(1, 2, []Reference{{3, 4}})
+
+	var b bytes.Buffer
+	g.WriteTo(&b)
+	valid := b.Bytes()
+
+	n := len(valid)
+
+	corrupt := func(f func(data []byte)) []byte {
+		data := append([]byte(nil), valid...)
+		f(data)
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		offset int
+	}{
+		{"footer", valid[n-8:], ErrTruncated, 0},
+		{"entries", valid[1:], ErrTruncated, n - 1 - 8},
+		{"magic", corrupt(func(data []byte) { data[n-1] = 0 }), ErrMagic, n - 4},
+		{"checksum", corrupt(func(data []byte) { data[0] ^= 0xff }), ErrChecksum, n - 16},
+		{"size", corrupt(func(data []byte) { binary.BigEndian.PutUint32(data[n-8:], 0) }), ErrCorrupt, n - 8},
+	}
+
+	for _, test := range tests {
+		var g Graph
+
+		_, err := g.ReadFrom(bytes.NewReader(test.data))
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: unexpected error %v, want %v", test.name, err, test.err)
+		}
+
+		var pe *ParseError
+
+		if !errors.As(err, &pe) {
+			t.Fatalf("%s: unexpected error type %T", test.name, err)
+		}
+
+		if pe.Offset != test.offset {
+			t.Fatalf("%s: unexpected offset %d, want %d", test.name, pe.Offset, test.offset)
+		}
+	}
+}
@@ -21,9 +21,45 @@ func invalidFormat() handler {
 	}
 }
 
-func doPrintTo(w io.Writer) func(n string) {
-	return func(n string) {
-		fmt.Fprintln(w, n)
+func doPrintTars(f format, w io.Writer) func(n string) error {
+	switch f {
+	case formatText:
+		return doPrintTo(w)
+	case formatJSON:
+		return doPrintTarJSONTo(w)
+	default:
+		return func(_ string) error {
+			return errInvalidFormat
+		}
+	}
+}
+
+func doPrintTo(w io.Writer) func(n string) error {
+	return func(n string) error {
+		_, err := fmt.Fprintln(w, n)
+		return err
+	}
+}
+
+func doPrintEntries(f format, w io.Writer) handler {
+	switch f {
+	case formatText:
+		return doPrintNameTo(w)
+	case formatJSON:
+		return doPrintEntryJSONTo(w)
+	default:
+		return invalidFormat()
+	}
+}
+
+func doPrintSegmentNames(f format, w io.Writer) handler {
+	switch f {
+	case formatText:
+		return doPrintSegmentNameTo(w)
+	case formatJSON:
+		return doPrintSegmentNameJSONTo(w)
+	default:
+		return invalidFormat()
 	}
 }
 
@@ -33,6 +69,8 @@ func doPrintBinaries(f format, w io.Writer) handler {
 		return doPrintHexTo(w)
 	case formatText:
 		return doPrintBinariesTo(w)
+	case formatJSON:
+		return doPrintBinariesJSONTo(w)
 	default:
 		return invalidFormat()
 	}
@@ -55,12 +93,27 @@ func doPrintBinariesTo(w io.Writer) handler {
 	}
 }
 
+func printGraph(p string, f format, w io.Writer) error {
+	switch f {
+	case formatDOT, formatGraphML:
+		d, err := readTarDiagram(p)
+		if err != nil {
+			return err
+		}
+		return writeDiagram(f, w, d)
+	default:
+		return onMatchingEntry(p, isGraph, doPrintGraph(f, w))
+	}
+}
+
 func doPrintGraph(f format, w io.Writer) handler {
 	switch f {
 	case formatHex:
 		return doPrintHexTo(w)
 	case formatText:
 		return doPrintGraphTo(w)
+	case formatJSON:
+		return doPrintGraphJSONTo(w)
 	default:
 		return invalidFormat()
 	}
@@ -87,6 +140,8 @@ func doPrintIndex(f format, w io.Writer) handler {
 		return doPrintHexTo(w)
 	case formatText:
 		return doPrintIndexTo(w)
+	case formatJSON:
+		return doPrintIndexJSONTo(w)
 	default:
 		return invalidFormat()
 	}
@@ -120,6 +175,8 @@ func doPrintSegment(f format, w io.Writer) handler {
 		return doPrintHexTo(w)
 	case formatText:
 		return doPrintSegmentTo(w)
+	case formatJSON:
+		return doPrintSegmentJSONTo(w)
 	default:
 		return invalidFormat()
 	}
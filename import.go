@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"./record"
+)
+
+// importTypeCodes maps the prefixes of typed JSON strings to property types.
+// These are the same type codes used by Oak to serialize properties to JSON.
+var importTypeCodes = map[string]record.Type{
+	"str": record.TypeString,
+	"bin": record.TypeBinary,
+	"lng": record.TypeLong,
+	"dou": record.TypeDouble,
+	"dat": record.TypeDate,
+	"boo": record.TypeBoolean,
+	"nam": record.TypeName,
+	"pat": record.TypePath,
+	"ref": record.TypeReference,
+	"wea": record.TypeWeakReference,
+	"uri": record.TypeURI,
+	"dec": record.TypeDecimal,
+}
+
+// importBlobIDPrefix is the prefix of JSON strings representing references to
+// external binaries.
+const importBlobIDPrefix = ":blobId:"
+
+// importStore reads a content tree from the JSON file at 'path' and writes it
+// as a segment store with a single revision to 'directory'. The revision and
+// the TAR entries have the same time as the first revision of a synthetic
+// store, so that the same file and seed always produce the same store.
+func importStore(path, directory string, seed int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	d.UseNumber()
+
+	var v interface{}
+
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("the root of the content tree must be a JSON object")
+	}
+
+	root, err := importNode(o, "")
+	if err != nil {
+		return err
+	}
+
+	var (
+		sw = &storeWriter{}
+		w  = record.NewWriter(rand.New(rand.NewSource(seed)), sw.addSegment)
+	)
+
+	w.Time = synthBaseTime
+
+	id, err := w.WriteNode(superRoot(root, &record.Node{}))
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	sw.addRevision(id, synthBaseTime)
+
+	return sw.writeTo(directory, 1, synthBaseTime)
+}
+
+// importNode converts a JSON object to a node. Nested objects are child nodes,
+// every other value is a property.
+func importNode(o map[string]interface{}, path string) (*record.Node, error) {
+	var names []string
+
+	for name := range o {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	n := &record.Node{}
+
+	for _, name := range names {
+		switch v := o[name].(type) {
+		case map[string]interface{}:
+			child, err := importNode(v, path+"/"+name)
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, record.Child{Name: name, Node: child})
+		case []interface{}:
+			p, err := importArray(name, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid property %s/%s: %v", path, name, err)
+			}
+			n.Properties = append(n.Properties, p)
+		default:
+			t, value, err := importValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid property %s/%s: %v", path, name, err)
+			}
+			n.Properties = append(n.Properties, record.Property{
+				Name:   name,
+				Type:   t,
+				Values: []record.Value{value},
+			})
+		}
+	}
+
+	return n, nil
+}
+
+func importArray(name string, a []interface{}) (record.Property, error) {
+	p := record.Property{Name: name, Type: record.TypeString, Array: true}
+
+	for i, v := range a {
+		t, value, err := importValue(v)
+		if err != nil {
+			return p, err
+		}
+		if i > 0 && t != p.Type {
+			return p, fmt.Errorf("mixed types %v and %v", p.Type, t)
+		}
+		p.Type = t
+		p.Values = append(p.Values, value)
+	}
+
+	return p, nil
+}
+
+// importValue converts a JSON value to the value of a property. Integers are
+// longs, other numbers are doubles. Strings can be prefixed by a type code,
+// and are plain strings otherwise.
+func importValue(v interface{}) (record.Type, record.Value, error) {
+	switch v := v.(type) {
+	case bool:
+		return record.TypeBoolean, record.Value{String: strconv.FormatBool(v)}, nil
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return record.TypeLong, record.Value{String: string(v)}, nil
+		}
+		return record.TypeDouble, record.Value{String: string(v)}, nil
+	case string:
+		return importString(v)
+	default:
+		return 0, record.Value{}, fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+func importString(s string) (record.Type, record.Value, error) {
+	if strings.HasPrefix(s, importBlobIDPrefix) {
+		return record.TypeBinary, record.Value{BlobID: s[len(importBlobIDPrefix):]}, nil
+	}
+
+	if len(s) < 4 || s[3] != ':' {
+		return record.TypeString, record.Value{String: s}, nil
+	}
+
+	t, ok := importTypeCodes[s[:3]]
+	if !ok {
+		return record.TypeString, record.Value{String: s}, nil
+	}
+
+	if t != record.TypeBinary {
+		return t, record.Value{String: s[4:]}, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s[4:])
+	if err != nil {
+		return 0, record.Value{}, fmt.Errorf("invalid binary: %v", err)
+	}
+
+	return t, record.Value{Binary: data}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"./record"
+	"./store"
+)
+
+func TestImportStore(t *testing.T) {
+	// Binaries longer than a medium value are stored as blocks in bulk
+	// segments, shorter ones are stored in data segments.
+
+	data := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	content := fmt.Sprintf(`{
+		"jcr:primaryType": "nam:rep:root",
+		"content": {
+			"jcr:primaryType": "nam:nt:unstructured",
+			"title": "Hello",
+			"count": 42,
+			"ratio": 0.5,
+			"tags": ["a", "b"],
+			"created": "dat:2020-01-01T00:00:00.000Z",
+			"asset": ":blobId:f20cc9f7902d6facdd7a9e260dc686d144de5ca3#108232",
+			"file": "bin:%s"
+		}
+	}`, base64.StdEncoding.EncodeToString(data))
+
+	var (
+		directory = t.TempDir()
+		path      = filepath.Join(directory, "content.json")
+		a         = filepath.Join(directory, "a")
+		b         = filepath.Join(directory, "b")
+	)
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{a, b} {
+		if err := importStore(path, d, 1); err != nil {
+			t.Fatalf("unable to import the content tree: %v", err)
+		}
+	}
+
+	// The same file and seed produce the same store.
+
+	for _, name := range []string{"data00000a.tar", "journal.log", "manifest"} {
+		ca, err := ioutil.ReadFile(filepath.Join(a, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb, err := ioutil.ReadFile(filepath.Join(b, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ca, cb) {
+			t.Fatalf("%s differs between imports", name)
+		}
+	}
+
+	s, err := store.Open(a)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		t.Fatalf("unable to read the journal: %v", err)
+	}
+
+	r := newRecordReader(s)
+
+	id, err := readPath(r, head, "/root/content")
+	if err != nil {
+		t.Fatalf("unable to read the node: %v", err)
+	}
+
+	n, err := r.ReadNode(id)
+	if err != nil {
+		t.Fatalf("unable to read the node: %v", err)
+	}
+
+	want := map[string]struct {
+		t      record.Type
+		array  bool
+		values []string
+	}{
+		"jcr:primaryType": {record.TypeName, false, []string{"nt:unstructured"}},
+		"title":           {record.TypeString, false, []string{"Hello"}},
+		"count":           {record.TypeLong, false, []string{"42"}},
+		"ratio":           {record.TypeDouble, false, []string{"0.5"}},
+		"tags":            {record.TypeString, true, []string{"a", "b"}},
+		"created":         {record.TypeDate, false, []string{"2020-01-01T00:00:00.000Z"}},
+		"asset":           {record.TypeBinary, false, nil},
+		"file":            {record.TypeBinary, false, nil},
+	}
+
+	if len(n.Properties) != len(want) {
+		t.Fatalf("unexpected number of properties: got %d, want %d", len(n.Properties), len(want))
+	}
+
+	for _, p := range n.Properties {
+		w, ok := want[p.Name]
+		if !ok {
+			t.Fatalf("unexpected property %s", p.Name)
+		}
+
+		if p.Type != w.t || p.Array != w.array {
+			t.Fatalf("unexpected type of %s: got %v, want %v", p.Name, p.Type, w.t)
+		}
+
+		if w.values == nil {
+			continue
+		}
+
+		if len(p.Values) != len(w.values) {
+			t.Fatalf("unexpected number of values of %s: %d", p.Name, len(p.Values))
+		}
+
+		for i, vid := range p.Values {
+			v, err := r.ReadString(vid)
+			if err != nil {
+				t.Fatalf("unable to read %s: %v", p.Name, err)
+			}
+			if v != w.values[i] {
+				t.Fatalf("unexpected value of %s: got %q, want %q", p.Name, v, w.values[i])
+			}
+		}
+	}
+
+	for _, p := range n.Properties {
+		switch p.Name {
+		case "asset":
+			blobID, err := r.ReadBlobID(p.Values[0])
+			if err != nil {
+				t.Fatalf("unable to read the blob ID: %v", err)
+			}
+			if blobID != "f20cc9f7902d6facdd7a9e260dc686d144de5ca3#108232" {
+				t.Fatalf("unexpected blob ID %s", blobID)
+			}
+		case "file":
+			var v bytes.Buffer
+
+			if _, err := r.WriteValueTo(p.Values[0], &v); err != nil {
+				t.Fatalf("unable to read the binary: %v", err)
+			}
+			if !bytes.Equal(v.Bytes(), data) {
+				t.Fatalf("binaries don't match")
+			}
+		}
+	}
+
+	// The blocks of the inline binary are stored in bulk segments.
+
+	ids, _, err := nodeRecords(r, id, n)
+	if err != nil {
+		t.Fatalf("unable to read the records of the node: %v", err)
+	}
+
+	bulk := 0
+
+	for _, id := range ids {
+		if isBulkSegmentID(recordSegmentID(id).String()) {
+			bulk++
+		}
+	}
+
+	if want := (len(data) + 4095) / 4096; bulk != want {
+		t.Fatalf("unexpected number of blocks in bulk segments: got %d, want %d", bulk, want)
+	}
+}
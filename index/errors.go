@@ -0,0 +1,45 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMagic is returned when the footer of the index doesn't end with one
+	// of the known magic numbers.
+	ErrMagic = errors.New("invalid magic")
+	// ErrChecksum is returned when the checksum in the footer doesn't match
+	// the checksum of the entries.
+	ErrChecksum = errors.New("invalid checksum")
+	// ErrTruncated is returned when the index is shorter than its footer
+	// declares.
+	ErrTruncated = errors.New("truncated data")
+	// ErrCorrupt is returned when the count and the size in the footer are
+	// inconsistent with each other.
+	ErrCorrupt = errors.New("corrupt footer")
+	// ErrUnsupportedVersion is returned when writing an index in a version
+	// that is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+// ParseError describes why an index couldn't be parsed. Err is one of the
+// errors defined by this package and can be tested with errors.Is. Offset is
+// the position in the data of the field that failed validation, and Expected
+// and Actual are the value the field should have had and the value it had.
+// For ErrTruncated they are the required and available number of bytes.
+type ParseError struct {
+	Err      error
+	Offset   int
+	Expected int64
+	Actual   int64
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d: expected %d, actual %d", e.Err, e.Offset, e.Expected, e.Actual)
+}
+
+// Unwrap returns Err.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
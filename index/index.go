@@ -10,6 +10,7 @@ import (
 
 // Index is a catalog of every segment stored in a TAR file.
 type Index struct {
+	Version int
 	Entries []Entry
 }
 
@@ -50,11 +51,81 @@ const (
 	v2Magic = 0x0a314b0a
 )
 
+// The versions of the serialization format of the index.
+const (
+	V1 = 1
+	V2 = 2
+)
+
+// WriteTo writes the index to 'w', using the serialization format specified by
+// the version of 'index'. If the version is zero, the latest version is used.
+// The entries are written in the order they appear in the index. Returns the
+// number of bytes written and an error.
+func (index *Index) WriteTo(w io.Writer) (int64, error) {
+	const footerSize = 16
+
+	var (
+		b     bytes.Buffer
+		magic uint32
+	)
+
+	version := index.Version
+
+	switch version {
+	case V1:
+		magic = v1Magic
+	case 0, V2:
+		version, magic = V2, v2Magic
+	default:
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, index.Version)
+	}
+
+	for _, e := range index.Entries {
+		writeLong(&b, e.Msb)
+		writeLong(&b, e.Lsb)
+		writeInt(&b, e.Position)
+		writeInt(&b, e.Size)
+		writeInt(&b, e.Generation)
+		if version == V2 {
+			writeInt(&b, e.FullGeneration)
+			if e.Compacted {
+				b.WriteByte(1)
+			} else {
+				b.WriteByte(0)
+			}
+		}
+	}
+
+	var (
+		checksum = crc32.ChecksumIEEE(b.Bytes())
+		size     = b.Len() + footerSize
+	)
+
+	writeInt(&b, int(checksum))
+	writeInt(&b, len(index.Entries))
+	writeInt(&b, size)
+	writeInt(&b, int(magic))
+
+	return b.WriteTo(w)
+}
+
+func writeInt(b *bytes.Buffer, v int) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(v))
+	b.Write(data[:])
+}
+
+func writeLong(b *bytes.Buffer, v uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], v)
+	b.Write(data[:])
+}
+
 func (index *Index) parse(data []byte) error {
 	n := len(data)
 
 	if n < 4 {
-		return fmt.Errorf("invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: 4, Actual: int64(n)}
 	}
 
 	magic := int(binary.BigEndian.Uint32(data[n-4:]))
@@ -66,7 +137,7 @@ func (index *Index) parse(data []byte) error {
 		return index.parseV2(data)
 	}
 
-	return fmt.Errorf("unrecognized magic %08x", magic)
+	return &ParseError{Err: ErrMagic, Offset: n - 4, Expected: v2Magic, Actual: int64(magic)}
 }
 
 func (index *Index) parseV1(data []byte) error {
@@ -94,7 +165,7 @@ func (index *Index) parseV1(data []byte) error {
 	n := len(data)
 
 	if n < footerSize {
-		return fmt.Errorf("invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: footerSize, Actual: int64(n)}
 	}
 
 	var (
@@ -106,23 +177,24 @@ func (index *Index) parseV1(data []byte) error {
 	)
 
 	if magic != indexMagic {
-		return fmt.Errorf("invalid magic %08x", magic)
+		return &ParseError{Err: ErrMagic, Offset: n - footerSize + footerMagicOffset, Expected: indexMagic, Actual: int64(magic)}
 	}
 
 	if size < count*indexEntrySize+footerSize {
-		return fmt.Errorf("invalid count or size")
+		return &ParseError{Err: ErrCorrupt, Offset: n - footerSize + footerSizeOffset, Expected: int64(count*indexEntrySize + footerSize), Actual: int64(size)}
 	}
 
 	if n < count*indexEntrySize+footerSize {
-		return fmt.Errorf("invalid count or data size")
+		return &ParseError{Err: ErrTruncated, Offset: n - footerSize + footerCountOffset, Expected: int64(count*indexEntrySize + footerSize), Actual: int64(n)}
 	}
 
 	entries := data[n-footerSize-count*indexEntrySize : n-footerSize]
 
-	if int(crc32.ChecksumIEEE(entries)) != checksum {
-		return fmt.Errorf("invalid checkusm")
+	if actual := crc32.ChecksumIEEE(entries); int(actual) != checksum {
+		return &ParseError{Err: ErrChecksum, Offset: n - footerSize + footerChecksumOffset, Expected: int64(checksum), Actual: int64(actual)}
 	}
 
+	index.Version = V1
 	index.Entries = nil
 
 	for i := 0; i < count; i++ {
@@ -177,7 +249,7 @@ func (index *Index) parseV2(data []byte) error {
 	n := len(data)
 
 	if n < footerSize {
-		return fmt.Errorf("invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: footerSize, Actual: int64(n)}
 	}
 
 	var (
@@ -189,23 +261,24 @@ func (index *Index) parseV2(data []byte) error {
 	)
 
 	if magic != indexMagic {
-		return fmt.Errorf("invalid magic %08x", magic)
+		return &ParseError{Err: ErrMagic, Offset: n - footerSize + footerMagicOffset, Expected: indexMagic, Actual: int64(magic)}
 	}
 
 	if size < count*indexEntrySize+footerSize {
-		return fmt.Errorf("invalid count or size")
+		return &ParseError{Err: ErrCorrupt, Offset: n - footerSize + footerSizeOffset, Expected: int64(count*indexEntrySize + footerSize), Actual: int64(size)}
 	}
 
 	if n < count*indexEntrySize+footerSize {
-		return fmt.Errorf("invalid count or data size")
+		return &ParseError{Err: ErrTruncated, Offset: n - footerSize + footerCountOffset, Expected: int64(count*indexEntrySize + footerSize), Actual: int64(n)}
 	}
 
 	entries := data[n-footerSize-count*indexEntrySize : n-footerSize]
 
-	if int(crc32.ChecksumIEEE(entries)) != checksum {
-		return fmt.Errorf("invalid checkusm")
+	if actual := crc32.ChecksumIEEE(entries); int(actual) != checksum {
+		return &ParseError{Err: ErrChecksum, Offset: n - footerSize + footerChecksumOffset, Expected: int64(checksum), Actual: int64(actual)}
 	}
 
+	index.Version = V2
 	index.Entries = nil
 
 	for i := 0; i < count; i++ {
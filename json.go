@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"./binaries"
+	"./graph"
+	"./index"
+	"./record"
+	"./segment"
+)
+
+// The types in this file define the objects printed by the JSON format. Every
+// object is printed on its own line, so the output can be processed one line
+// at a time. The names of the fields are part of the output format and must
+// not change.
+
+type jsonTar struct {
+	Name string `json:"name"`
+}
+
+type jsonEntry struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SegmentID string `json:"segmentId,omitempty"`
+}
+
+type jsonSegmentName struct {
+	Type      string `json:"type"`
+	SegmentID string `json:"segmentId"`
+}
+
+type jsonSegment struct {
+	SegmentID      string          `json:"segmentId"`
+	Type           string          `json:"type"`
+	Version        int             `json:"version"`
+	Generation     int             `json:"generation"`
+	FullGeneration int             `json:"fullGeneration"`
+	Compacted      bool            `json:"compacted"`
+	References     []jsonReference `json:"references"`
+	Records        []jsonRecord    `json:"records"`
+}
+
+type jsonReference struct {
+	Number    int    `json:"number"`
+	SegmentID string `json:"segmentId"`
+}
+
+type jsonRecord struct {
+	Number int    `json:"number"`
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+}
+
+type jsonIndexEntry struct {
+	Type           string `json:"type"`
+	SegmentID      string `json:"segmentId"`
+	Position       int    `json:"position"`
+	Size           int    `json:"size"`
+	Generation     int    `json:"generation"`
+	FullGeneration int    `json:"fullGeneration"`
+	Compacted      bool   `json:"compacted"`
+}
+
+type jsonGraphEntry struct {
+	SegmentID  string   `json:"segmentId"`
+	References []string `json:"references"`
+}
+
+type jsonGraphSegment struct {
+	SegmentID      string   `json:"segmentId"`
+	Type           string   `json:"type"`
+	Tar            string   `json:"tar,omitempty"`
+	Generation     int      `json:"generation"`
+	FullGeneration int      `json:"fullGeneration"`
+	Compacted      bool     `json:"compacted"`
+	Missing        bool     `json:"missing"`
+	InDegree       int      `json:"inDegree"`
+	OutDegree      int      `json:"outDegree"`
+	References     []string `json:"references"`
+	Dangling       []string `json:"dangling"`
+}
+
+type jsonBinaries struct {
+	Generation     int      `json:"generation"`
+	FullGeneration int      `json:"fullGeneration"`
+	Compacted      bool     `json:"compacted"`
+	SegmentID      string   `json:"segmentId"`
+	References     []string `json:"references"`
+}
+
+func doPrintTarJSONTo(w io.Writer) func(n string) error {
+	e := json.NewEncoder(w)
+	return func(n string) error {
+		return e.Encode(jsonTar{Name: n})
+	}
+}
+
+func doPrintEntryJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(n string, r io.Reader) error {
+		size, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return err
+		}
+		entry := jsonEntry{Name: n, Size: size}
+		if isAnySegment(n) {
+			entry.SegmentID = normalizeSegmentID(entryNameToSegmentID(n))
+		}
+		return e.Encode(entry)
+	}
+}
+
+func doPrintSegmentNameJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(n string, _ io.Reader) error {
+		id := normalizeSegmentID(entryNameToSegmentID(n))
+		return e.Encode(jsonSegmentName{Type: segmentType(id), SegmentID: id})
+	}
+}
+
+func doPrintSegmentJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(n string, r io.Reader) error {
+		var s segment.Segment
+		if _, err := s.ReadFrom(r); err != nil {
+			return err
+		}
+		return e.Encode(newJSONSegment(normalizeSegmentID(entryNameToSegmentID(n)), &s))
+	}
+}
+
+func newJSONSegment(id string, s *segment.Segment) jsonSegment {
+	o := jsonSegment{
+		SegmentID:      id,
+		Type:           segmentType(id),
+		Version:        s.Version,
+		Generation:     s.Generation,
+		FullGeneration: s.FullGeneration,
+		Compacted:      s.Compacted,
+		References:     []jsonReference{},
+		Records:        []jsonRecord{},
+	}
+	for i, r := range s.References {
+		o.References = append(o.References, jsonReference{
+			Number:    i + 1,
+			SegmentID: segmentID(r.Msb, r.Lsb),
+		})
+	}
+	for _, r := range s.Records {
+		o.Records = append(o.Records, jsonRecord{
+			Number: r.Number,
+			Type:   recordType(r.Type),
+			Offset: r.Offset,
+		})
+	}
+	return o
+}
+
+func doPrintIndexJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(_ string, r io.Reader) error {
+		var idx index.Index
+		if _, err := idx.ReadFrom(r); err != nil {
+			return err
+		}
+		for _, entry := range idx.Entries {
+			if err := e.Encode(newJSONIndexEntry(entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newJSONIndexEntry(entry index.Entry) jsonIndexEntry {
+	id := segmentID(entry.Msb, entry.Lsb)
+	return jsonIndexEntry{
+		Type:           segmentType(id),
+		SegmentID:      id,
+		Position:       entry.Position,
+		Size:           entry.Size,
+		Generation:     entry.Generation,
+		FullGeneration: entry.FullGeneration,
+		Compacted:      entry.Compacted,
+	}
+}
+
+func doPrintGraphJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(_ string, r io.Reader) error {
+		var gph graph.Graph
+		if _, err := gph.ReadFrom(r); err != nil {
+			return err
+		}
+		for _, entry := range gph.Entries {
+			if err := e.Encode(newJSONGraphEntry(entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newJSONGraphEntry(entry graph.Entry) jsonGraphEntry {
+	o := jsonGraphEntry{
+		SegmentID:  segmentID(entry.Msb, entry.Lsb),
+		References: []string{},
+	}
+	for _, r := range entry.References {
+		o.References = append(o.References, segmentID(r.Msb, r.Lsb))
+	}
+	return o
+}
+
+func doPrintBinariesJSONTo(w io.Writer) handler {
+	e := json.NewEncoder(w)
+	return func(_ string, r io.Reader) error {
+		var bns binaries.Binaries
+		if _, err := bns.ReadFrom(r); err != nil {
+			return err
+		}
+		for _, o := range newJSONBinaries(&bns) {
+			if err := e.Encode(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newJSONBinaries(bns *binaries.Binaries) []jsonBinaries {
+	var objects []jsonBinaries
+	for _, g := range bns.Generations {
+		for _, s := range g.Segments {
+			objects = append(objects, jsonBinaries{
+				Generation:     g.Generation,
+				FullGeneration: g.FullGeneration,
+				Compacted:      g.Compacted,
+				SegmentID:      segmentID(s.Msb, s.Lsb),
+				References:     append([]string{}, s.References...),
+			})
+		}
+	}
+	return objects
+}
+
+type jsonStoreTar struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Segments int    `json:"segments"`
+}
+
+type jsonNeighbourhood struct {
+	Segment    jsonGraphSegment   `json:"segment"`
+	References []jsonGraphSegment `json:"references"`
+	Referrers  []jsonGraphSegment `json:"referrers"`
+}
+
+type jsonJournalEntry struct {
+	RecordID string `json:"recordId"`
+	Time     string `json:"time,omitempty"`
+}
+
+type jsonRecordInfo struct {
+	RecordID  string          `json:"recordId"`
+	SegmentID string          `json:"segmentId"`
+	Type      string          `json:"type"`
+	Size      int             `json:"size"`
+	Node      *jsonNodeRecord `json:"node,omitempty"`
+	Length    *int            `json:"length,omitempty"`
+	BlobID    string          `json:"blobId,omitempty"`
+}
+
+type jsonNodeRecord struct {
+	StableID   string               `json:"stableId"`
+	Template   string               `json:"template"`
+	Children   []jsonChildRecord    `json:"children"`
+	Properties []jsonPropertyRecord `json:"properties"`
+}
+
+type jsonChildRecord struct {
+	Name     string `json:"name"`
+	RecordID string `json:"recordId"`
+}
+
+type jsonPropertyRecord struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Array  bool     `json:"array"`
+	Values []string `json:"values"`
+}
+
+type jsonNode struct {
+	Path       string            `json:"path"`
+	RecordID   string            `json:"recordId"`
+	Children   []jsonChildRecord `json:"children"`
+	Properties []jsonProperty    `json:"properties"`
+}
+
+type jsonProperty struct {
+	Name   string      `json:"name"`
+	Type   string      `json:"type"`
+	Array  bool        `json:"array"`
+	Values []jsonValue `json:"values"`
+}
+
+// jsonValue is the value of a property. Binaries are not included, and only
+// their length or their blob ID is.
+type jsonValue struct {
+	RecordID string  `json:"recordId"`
+	Value    *string `json:"value,omitempty"`
+	Length   *int64  `json:"length,omitempty"`
+	BlobID   string  `json:"blobId,omitempty"`
+}
+
+func newJSONNodeRecord(n *record.NodeRecord) *jsonNodeRecord {
+	o := &jsonNodeRecord{
+		StableID:   n.StableID.String(),
+		Template:   n.Template.String(),
+		Children:   newJSONChildRecords(n.Children),
+		Properties: []jsonPropertyRecord{},
+	}
+	for _, p := range n.Properties {
+		po := jsonPropertyRecord{
+			Name:   p.Name,
+			Type:   p.Type.String(),
+			Array:  p.Array,
+			Values: []string{},
+		}
+		for _, v := range p.Values {
+			po.Values = append(po.Values, v.String())
+		}
+		o.Properties = append(o.Properties, po)
+	}
+	return o
+}
+
+func newJSONChildRecords(children []record.ChildRecord) []jsonChildRecord {
+	objects := []jsonChildRecord{}
+	for _, c := range children {
+		objects = append(objects, jsonChildRecord{Name: c.Name, RecordID: c.ID.String()})
+	}
+	return objects
+}
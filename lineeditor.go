@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineEditor reads lines from a terminal, with minimal editing, history and
+// completion. If the input is not a terminal, lines are read as they are.
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prompt  string
+	raw     bool
+	history []string
+
+	// complete returns the start of the word being completed in 'line' and
+	// the escaped words that can replace it. Words not starting with the
+	// word being completed are ignored.
+	complete func(line string) (int, []string)
+}
+
+// readLine reads the next line. It returns io.EOF at the end of the input, or
+// if Ctrl-D is pressed on an empty line.
+func (e *lineEditor) readLine() (string, error) {
+	if !e.raw {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	var (
+		line    string
+		history = len(e.history)
+	)
+
+	fmt.Fprint(e.out, e.prompt)
+
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case b == '\r' || b == '\n':
+			fmt.Fprintln(e.out)
+			if line != "" {
+				e.history = append(e.history, line)
+			}
+			return line, nil
+		case b == 3: // Ctrl-C
+			fmt.Fprintln(e.out, "^C")
+			line = ""
+		case b == 4: // Ctrl-D
+			if line == "" {
+				fmt.Fprintln(e.out)
+				return "", io.EOF
+			}
+			continue
+		case b == 8 || b == 127: // Backspace
+			if _, size := utf8.DecodeLastRuneInString(line); size > 0 {
+				line = line[:len(line)-size]
+			}
+		case b == 21: // Ctrl-U
+			line = ""
+		case b == '\t':
+			line = e.completeLine(line)
+		case b == 27: // Escape sequence
+			switch e.readEscape() {
+			case 'A':
+				if history > 0 {
+					history--
+					line = e.history[history]
+				}
+			case 'B':
+				if history < len(e.history) {
+					history++
+					line = ""
+					if history < len(e.history) {
+						line = e.history[history]
+					}
+				}
+			}
+		case b >= utf8.RuneSelf:
+			// The byte starts a multi-byte character, which is inserted
+			// only if it is valid UTF-8.
+			e.in.UnreadByte()
+			r, size, err := e.in.ReadRune()
+			if err != nil {
+				return "", err
+			}
+			if r == utf8.RuneError && size == 1 {
+				continue
+			}
+			line += string(r)
+		case b >= 32:
+			line += string(b)
+		}
+
+		fmt.Fprintf(e.out, "\r\033[K%s%s", e.prompt, line)
+	}
+}
+
+// readEscape reads the rest of an escape sequence and returns its final byte.
+// Only the arrow keys are handled, every other sequence is ignored.
+func (e *lineEditor) readEscape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || b != '[' {
+		return 0
+	}
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil || b >= 0x40 && b <= 0x7e {
+			return b
+		}
+	}
+}
+
+// completeLine completes the last word of 'line'. If a single word matches, it
+// replaces the last word. If more than one word matches, the last word is
+// extended to their common prefix, or the matching words are printed if the
+// last word can't be extended.
+func (e *lineEditor) completeLine(line string) string {
+	if e.complete == nil {
+		return line
+	}
+
+	start, words := e.complete(line)
+
+	var (
+		prefix  = line[start:]
+		matches []string
+	)
+
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			matches = append(matches, w)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		fmt.Fprint(e.out, "\a")
+		return line
+	case 1:
+		if strings.HasSuffix(matches[0], "/") {
+			return line[:start] + matches[0]
+		}
+		return line[:start] + matches[0] + " "
+	}
+
+	if common := commonPrefix(matches); len(common) > len(prefix) {
+		return line[:start] + common
+	}
+
+	sort.Strings(matches)
+
+	fmt.Fprintf(e.out, "\n%s\n", strings.Join(matches, "  "))
+
+	return line
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitWords splits 'line' in words separated by spaces. A backslash escapes
+// the character following it, so that words can contain spaces.
+func splitWords(line string) []string {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+	)
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped, inWord = true, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// lastWordStart returns the position in 'line' where its last word starts.
+func lastWordStart(line string) int {
+	start := 0
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ' ' || c == '\t':
+			start = i + 1
+		}
+	}
+	return start
+}
+
+// escapeWord escapes the spaces and the backslashes in 'word', so that
+// splitWords returns it as a single word.
+func escapeWord(word string) string {
+	return strings.NewReplacer(`\`, `\\`, " ", `\ `).Replace(word)
+}
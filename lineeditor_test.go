@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"héllo\r", "héllo"},
+		{"αβγ\x7fδ\r", "αβδ"},
+		{"nœud\x7f\x7f\x7fé\r", "né"},
+		{"a\xffb\r", "ab"},
+		{"cd n\t\r", "cd n"},
+		{"cd nœ\t\r", "cd nœud/"},
+		{"cd nœ\x7f\t\r", "cd n"},
+	}
+
+	for _, test := range tests {
+		e := &lineEditor{
+			in:  bufio.NewReader(strings.NewReader(test.input)),
+			out: ioutil.Discard,
+			raw: true,
+			complete: func(line string) (int, []string) {
+				return lastWordStart(line), []string{"nœud/", "nid/"}
+			},
+		}
+
+		line, err := e.readLine()
+		if err != nil {
+			t.Fatalf("unable to read %q: %v", test.input, err)
+		}
+
+		if line != test.want {
+			t.Fatalf("unexpected line for %q: got %q, want %q", test.input, line, test.want)
+		}
+	}
+}
@@ -25,11 +25,28 @@ func newRootCommand() *cobra.Command {
 	cmd.AddCommand(newIndexCommand())
 	cmd.AddCommand(newGraphCommand())
 	cmd.AddCommand(newBinariesCommand())
+	cmd.AddCommand(newSynthCommand())
+	cmd.AddCommand(newImportCommand())
+	cmd.AddCommand(newReferrersCommand())
+	cmd.AddCommand(newWhyCommand())
+	cmd.AddCommand(newGarbageCommand())
+	cmd.AddCommand(newStatsCommand())
+	cmd.AddCommand(newRecordStatsCommand())
+	cmd.AddCommand(newDiskUsageCommand())
+	cmd.AddCommand(newBlobsCheckCommand())
+	cmd.AddCommand(newBlobsCommand())
+	cmd.AddCommand(newExtractBinaryCommand())
+	cmd.AddCommand(newTarDiffCommand())
+	cmd.AddCommand(newStoreDiffCommand())
+	cmd.AddCommand(newShellCommand())
+	cmd.AddCommand(newServeCommand())
+	cmd.AddCommand(newUICommand())
 	return cmd
 }
 
 func newTarsCommand() *cobra.Command {
 	var all bool
+	f := formatText
 	cmd := &cobra.Command{
 		Use:   "tars [dir]",
 		Short: "Prints the TAR files at the provided path.",
@@ -46,18 +63,20 @@ func newTarsCommand() *cobra.Command {
 			if len(args) == 1 {
 				directory = args[0]
 			}
-			if err := forEachTarFile(directory, all, doPrintTo(os.Stdout)); err != nil {
+			if err := forEachTarFile(directory, all, doPrintTars(f, os.Stdout)); err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to print TAR files: %v.\n", err)
 				os.Exit(1)
 			}
 		},
 	}
 	cmd.Flags().BoolVar(&all, "all", false, "List both active and non-active TAR files")
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatJSON), "format", "Output format (text, json)")
 	return cmd
 }
 
 func newEntriesCommand() *cobra.Command {
-	return &cobra.Command{
+	f := formatText
+	cmd := &cobra.Command{
 		Use:   "entries file",
 		Short: "Prints the entries from the specified TAR file.",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -69,16 +88,19 @@ func newEntriesCommand() *cobra.Command {
 				fmt.Fprintf(os.Stderr, "Too few arguments.\n")
 				os.Exit(1)
 			}
-			if err := forEachEntry(args[0], doPrintNameTo(os.Stdout)); err != nil {
+			if err := forEachEntry(args[0], doPrintEntries(f, os.Stdout)); err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to print TAR entries: %v.\n", err)
 				os.Exit(1)
 			}
 		},
 	}
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatJSON), "format", "Output format (text, json)")
+	return cmd
 }
 
 func newSegmentsCommand() *cobra.Command {
-	return &cobra.Command{
+	f := formatText
+	cmd := &cobra.Command{
 		Use:   "segments file",
 		Short: "Prints the identifiers of the segments from the specified TAR file.",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -90,12 +112,14 @@ func newSegmentsCommand() *cobra.Command {
 				fmt.Fprintln(os.Stderr, "Too few arguments.")
 				os.Exit(1)
 			}
-			if err := forEachMatchingEntry(args[0], isAnySegment, doPrintSegmentNameTo(os.Stdout)); err != nil {
+			if err := forEachMatchingEntry(args[0], isAnySegment, doPrintSegmentNames(f, os.Stdout)); err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to print segment IDs: %v.\n", err)
 				os.Exit(1)
 			}
 		},
 	}
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatJSON), "format", "Output format (text, json)")
+	return cmd
 }
 
 func newSegmentCommand() *cobra.Command {
@@ -118,7 +142,7 @@ func newSegmentCommand() *cobra.Command {
 			}
 		},
 	}
-	cmd.Flags().Var(&f, "format", "Output format (text, hex)")
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatHex, formatJSON), "format", "Output format (text, hex, json)")
 	return cmd
 }
 
@@ -142,16 +166,28 @@ func newIndexCommand() *cobra.Command {
 			}
 		},
 	}
-	cmd.Flags().Var(&f, "format", "Output format (text, hex)")
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatHex, formatJSON), "format", "Output format (text, hex, json)")
 	return cmd
 }
 
 func newGraphCommand() *cobra.Command {
+	var directory string
 	f := formatText
 	cmd := &cobra.Command{
-		Use:   "graph",
-		Short: "Prints the graph from the specified TAR file",
+		Use:   "graph [file]",
+		Short: "Prints the graph from the specified TAR file, or the combined graph of a store",
 		Run: func(cmd *cobra.Command, args []string) {
+			if directory != "" {
+				if len(args) > 0 {
+					fmt.Fprintln(os.Stderr, "Too many arguments.")
+					os.Exit(1)
+				}
+				if err := printStoreGraph(directory, f, os.Stdout); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to print the graph: %v.\n", err)
+					os.Exit(1)
+				}
+				return
+			}
 			if len(args) > 1 {
 				fmt.Fprintln(os.Stderr, "Too many arguments.")
 				os.Exit(1)
@@ -160,13 +196,14 @@ func newGraphCommand() *cobra.Command {
 				fmt.Fprintln(os.Stderr, "Too few arguments.")
 				os.Exit(1)
 			}
-			if err := onMatchingEntry(args[0], isGraph, doPrintGraph(f, os.Stdout)); err != nil {
+			if err := printGraph(args[0], f, os.Stdout); err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to print the graph: %v.\n", err)
 				os.Exit(1)
 			}
 		},
 	}
-	cmd.Flags().Var(&f, "format", "Output format (text, hex)")
+	cmd.Flags().StringVar(&directory, "store", "", "Combine the graphs of the active TAR files in this directory")
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatHex, formatJSON, formatDOT, formatGraphML), "format", "Output format (text, hex, json, dot, graphml)")
 	return cmd
 }
 
@@ -190,33 +227,443 @@ func newBinariesCommand() *cobra.Command {
 			}
 		},
 	}
-	cmd.Flags().Var(&f, "format", "Output format (text, hex)")
+	cmd.Flags().Var(newFormatFlag(&f, formatText, formatHex, formatJSON), "format", "Output format (text, hex, json)")
+	return cmd
+}
+
+func newSynthCommand() *cobra.Command {
+	var o synthOptions
+	cmd := &cobra.Command{
+		Use:   "synth dir",
+		Short: "Generates a synthetic segment store in the specified directory",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := synthesize(args[0], o); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to generate the segment store: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().Int64Var(&o.seed, "seed", 0, "Seed for the random generator")
+	cmd.Flags().IntVar(&o.tars, "tars", 1, "Number of TAR files")
+	cmd.Flags().IntVar(&o.generations, "generations", 1, "Number of generations")
+	cmd.Flags().IntVar(&o.depth, "depth", 3, "Depth of the content tree")
+	cmd.Flags().IntVar(&o.fanout, "fanout", 5, "Number of children of every node")
+	cmd.Flags().IntVar(&o.properties, "properties", 5, "Number of properties of every node")
+	cmd.Flags().IntVar(&o.binaries, "binaries", 0, "Number of references to external binaries")
+	cmd.Flags().Float64Var(&o.bulkRatio, "bulk-ratio", 0, "Approximate ratio of bulk segments")
+	cmd.Flags().IntVar(&o.journal, "journal", 1, "Number of revisions in the journal")
+	return cmd
+}
+
+func newImportCommand() *cobra.Command {
+	var seed int64
+	cmd := &cobra.Command{
+		Use:   "import file dir",
+		Short: "Writes the content tree from the specified JSON file as a segment store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := importStore(args[0], args[1], seed); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to import the content tree: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().Int64Var(&seed, "seed", 0, "Seed for the generation of segment IDs")
+	return cmd
+}
+
+func newReferrersCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "referrers dir id",
+		Short: "Prints the segments referencing the specified segment, and the paths of their nodes",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printReferrers(args[0], args[1], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to print the referrers: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func newWhyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "why dir id",
+		Short: "Prints the shortest chains of references from the head and the checkpoints to the specified segment",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printWhy(args[0], args[1], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to explain why the segment is retained: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func newGarbageCommand() *cobra.Command {
+	o := garbageOptions{retainedGenerations: 2, gcType: "full"}
+	cmd := &cobra.Command{
+		Use:   "garbage dir",
+		Short: "Estimates the space that cleanup can reclaim from the specified store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printGarbage(args[0], o, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to estimate the garbage: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().IntVar(&o.retainedGenerations, "retained-generations", o.retainedGenerations, "Number of generations retained by cleanup")
+	cmd.Flags().StringVar(&o.gcType, "type", o.gcType, "Type of the last compaction (full, tail)")
+	return cmd
+}
+
+func newStatsCommand() *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "stats [dir]",
+		Short: "Prints a summary of the TAR files at the provided path",
+		Run: func(cmd *cobra.Command, args []string) {
+			directory, err := os.Getwd()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to determine the working directory: %v.\n", err)
+				os.Exit(1)
+			}
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) == 1 {
+				directory = args[0]
+			}
+			if err := printStats(directory, all, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to print the summary: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Include both active and non-active TAR files")
+	return cmd
+}
+
+func newRecordStatsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "records-stats path [id]",
+		Short: "Prints the number and size of the records by type, for a store, a TAR file or a segment",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			var id string
+			if len(args) == 2 {
+				id = args[1]
+			}
+			if err := printRecordStats(args[0], id, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to print the record statistics: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func newDiskUsageCommand() *cobra.Command {
+	var depth int
+	cmd := &cobra.Command{
+		Use:   "du dir [path]",
+		Short: "Prints the space used by the records of the specified path and its subtrees",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			path := "/root"
+			if len(args) == 2 {
+				path = args[1]
+			}
+			if err := printDiskUsage(args[0], path, depth, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to print the disk usage: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().IntVar(&depth, "depth", 1, "Number of levels of subtrees to print")
+	return cmd
+}
+
+func newBlobsCheckCommand() *cobra.Command {
+	var datastore string
+	cmd := &cobra.Command{
+		Use:   "blobs-check dir",
+		Short: "Checks the binary references of the specified store against a FileDataStore",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if datastore == "" {
+				fmt.Fprintln(os.Stderr, "The data store directory must be specified.")
+				os.Exit(1)
+			}
+			if err := printBlobsCheck(args[0], datastore, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to check the binary references: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&datastore, "datastore", "", "Directory of the FileDataStore")
+	return cmd
+}
+
+func newBlobsCommand() *cobra.Command {
+	var summary bool
+	cmd := &cobra.Command{
+		Use:   "blobs dir",
+		Short: "Lists the blobs referenced by the specified store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printBlobs(args[0], summary, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to list the blobs: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&summary, "summary", false, "Print only the number of blobs and their total length")
+	return cmd
+}
+
+func newExtractBinaryCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "extract-binary dir value",
+		Short: "Writes the content of a value, specified by a property path or a record ID, to a file",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := extractBinary(args[0], args[1], output); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to extract the value: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file, the standard output if not specified")
+	return cmd
+}
+
+func newTarDiffCommand() *cobra.Command {
+	var content bool
+	cmd := &cobra.Command{
+		Use:   "tardiff a b",
+		Short: "Compares the segments of two TAR files",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printTarDiff(args[0], args[1], content, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to compare the TAR files: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&content, "content", false, "Compare the content of the segments in both TAR files")
+	return cmd
+}
+
+func newStoreDiffCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "storediff a b",
+		Short: "Compares the segments of two stores",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 2 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := printStoreDiff(args[0], args[1], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to compare the stores: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func newShellCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "shell dir",
+		Short: "Starts an interactive shell to explore the specified store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := runShell(args[0], os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to run the shell: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func newServeCommand() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "serve dir",
+		Short: "Serves a read-only JSON API for the specified store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := serveStore(args[0], addr); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to serve the store: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "Address to listen on")
+	return cmd
+}
+
+func newUICommand() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "ui dir",
+		Short: "Serves a web UI to browse the specified store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "Too many arguments.")
+				os.Exit(1)
+			}
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, "Too few arguments.")
+				os.Exit(1)
+			}
+			if err := serveUI(args[0], addr); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to serve the web UI: %v.\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "Address to listen on")
 	return cmd
 }
 
 type format string
 
 const (
-	formatText format = "text"
-	formatHex  format = "hex"
+	formatText    format = "text"
+	formatHex     format = "hex"
+	formatJSON    format = "json"
+	formatDOT     format = "dot"
+	formatGraphML format = "graphml"
 )
 
-func (f *format) String() string {
-	return string(*f)
+// formatFlag is the value of a -format flag. Only the formats supported by a
+// command are accepted, so that an unsupported format is rejected when the
+// flags are parsed.
+type formatFlag struct {
+	format  *format
+	allowed []format
+}
+
+func newFormatFlag(f *format, allowed ...format) *formatFlag {
+	return &formatFlag{format: f, allowed: allowed}
+}
+
+func (f *formatFlag) String() string {
+	if f.format == nil {
+		return ""
+	}
+	return string(*f.format)
 }
 
-func (f *format) Set(s string) error {
-	switch format(s) {
-	case formatHex:
-		*f = formatHex
-	case formatText:
-		*f = formatText
-	default:
-		return fmt.Errorf("Invalid format '%s'", s)
+func (f *formatFlag) Set(s string) error {
+	for _, a := range f.allowed {
+		if format(s) == a {
+			*f.format = a
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("Invalid format '%s'", s)
 }
 
-func (f *format) Type() string {
+func (f *formatFlag) Type() string {
 	return "format"
 }
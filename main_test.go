@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestFormatFlag(t *testing.T) {
+	f := formatText
+
+	v := newFormatFlag(&f, formatText, formatJSON)
+
+	if err := v.Set("json"); err != nil || f != formatJSON {
+		t.Fatalf("unable to set a supported format: %v", err)
+	}
+
+	for _, s := range []string{"hex", "dot", "graphml", ""} {
+		if err := v.Set(s); err == nil {
+			t.Fatalf("unsupported format %q accepted", s)
+		}
+	}
+
+	if f != formatJSON {
+		t.Fatalf("rejected format changed the value to %q", f)
+	}
+}
+
+func TestGarbageOptions(t *testing.T) {
+	if err := (garbageOptions{retainedGenerations: 2, gcType: "full"}).validate(); err != nil {
+		t.Fatalf("default options rejected: %v", err)
+	}
+
+	for _, n := range []int{0, -1} {
+		if err := (garbageOptions{retainedGenerations: n, gcType: "full"}).validate(); err == nil {
+			t.Fatalf("%d retained generations accepted", n)
+		}
+	}
+
+	if err := (garbageOptions{retainedGenerations: 1, gcType: "other"}).validate(); err == nil {
+		t.Fatalf("invalid type of compaction accepted")
+	}
+}
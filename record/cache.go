@@ -0,0 +1,71 @@
+package record
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is the number of bytes of segments kept in memory by a
+// reader created by NewReader.
+const DefaultCacheSize = 256 * 1024 * 1024
+
+// segmentCache keeps the most recently used segments, up to 'maxSize' bytes of
+// segment data. The most recently used segment is kept even if it is bigger
+// than 'maxSize'. The cache can be used by more than one goroutine at a time.
+type segmentCache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	order   *list.List
+	entries map[[2]uint64]*list.Element
+}
+
+func newSegmentCache(maxSize int) *segmentCache {
+	return &segmentCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[[2]uint64]*list.Element),
+	}
+}
+
+// get returns the segment identified by 'key', or nil if the segment is not in
+// the cache.
+func (c *segmentCache) get(key [2]uint64) *readerSegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*readerSegment)
+}
+
+// add adds the segment 's' identified by 'key' to the cache, and evicts the
+// least recently used segments exceeding the size of the cache. If another
+// goroutine added the segment first, the segment already in the cache is
+// returned instead of 's'.
+func (c *segmentCache) add(key [2]uint64, s *readerSegment) *readerSegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*readerSegment)
+	}
+
+	c.entries[key] = c.order.PushFront(s)
+	c.size += len(s.data)
+
+	for c.size > c.maxSize && c.order.Len() > 1 {
+		e := c.order.Back()
+		evicted := c.order.Remove(e).(*readerSegment)
+		delete(c.entries, [2]uint64{evicted.msb, evicted.lsb})
+		c.size -= len(evicted.data)
+	}
+
+	return s
+}
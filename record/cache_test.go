@@ -0,0 +1,160 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"../segment"
+)
+
+// valueSegments returns a store of 'n' data segments, each containing the
+// string value "hi", and the IDs of the values.
+func valueSegments(t *testing.T, n int) (memoryStore, []ID) {
+	var (
+		m   = make(memoryStore)
+		ids []ID
+	)
+
+	for i := 0; i < n; i++ {
+		builder := &segment.Builder{Version: 13}
+
+		if _, err := builder.AddRecord(segment.RecordTypeValue, []byte{2, 'h', 'i'}); err != nil {
+			t.Fatalf("unable to add the record: %v", err)
+		}
+
+		var b bytes.Buffer
+
+		if _, err := builder.WriteTo(&b); err != nil {
+			t.Fatalf("unable to write the segment: %v", err)
+		}
+
+		id := ID{Msb: uint64(i + 1), Lsb: 0xa000000000000002}
+		m[[2]uint64{id.Msb, id.Lsb}] = b.Bytes()
+		ids = append(ids, id)
+	}
+
+	return m, ids
+}
+
+func TestReaderCacheEviction(t *testing.T) {
+	var (
+		m, ids = valueSegments(t, 3)
+		reads  int
+	)
+
+	size := len(m[[2]uint64{ids[0].Msb, ids[0].Lsb}])
+
+	r := NewReaderSize(func(msb, lsb uint64) ([]byte, error) {
+		reads++
+		return m.read(msb, lsb)
+	}, 2*size)
+
+	// Every step reads a segment and checks how many segments were read from
+	// the store so far. The cache holds two segments.
+	steps := []struct {
+		segment int
+		reads   int
+	}{
+		{0, 1},
+		{1, 2},
+		{2, 3}, // evicts 0
+		{1, 3},
+		{0, 4}, // evicts 2
+		{1, 4},
+		{2, 5}, // evicts 0
+	}
+
+	for i, step := range steps {
+		s, err := r.ReadString(ids[step.segment])
+		if err != nil {
+			t.Fatalf("step %d: unable to read the record: %v", i, err)
+		}
+
+		if s != "hi" {
+			t.Fatalf("step %d: unexpected value %q", i, s)
+		}
+
+		if reads != step.reads {
+			t.Fatalf("step %d: unexpected number of reads: got %d, want %d", i, reads, step.reads)
+		}
+
+		if r.segments.size > 2*size {
+			t.Fatalf("step %d: cache size %d exceeds %d", i, r.segments.size, 2*size)
+		}
+	}
+}
+
+func TestReaderCacheOversized(t *testing.T) {
+	m, ids := valueSegments(t, 2)
+
+	r := NewReaderSize(m.read, 1)
+
+	for _, id := range ids {
+		if _, err := r.ReadString(id); err != nil {
+			t.Fatalf("unable to read the value: %v", err)
+		}
+	}
+
+	if n := r.segments.order.Len(); n != 1 {
+		t.Fatalf("unexpected number of cached segments: got %d, want 1", n)
+	}
+}
+
+func TestReaderConcurrent(t *testing.T) {
+	var (
+		m    = make(memoryStore)
+		w    = NewWriter(rand.New(rand.NewSource(1)), m.flush)
+		tree = testTree()
+	)
+
+	id, err := w.WriteNode(tree)
+	if err != nil {
+		t.Fatalf("unable to write the tree: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	// The cache is smaller than the segments of the tree, so that segments
+	// are evicted while they are read.
+	r := NewReaderSize(m.read, 64*1024)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 8)
+	)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			reader := r
+
+			if i%2 == 1 {
+				reader = r.Trace(func(ID) {})
+			}
+
+			got, err := readTree(reader, id)
+			if err == nil && !reflect.DeepEqual(got, tree) {
+				err = errors.New("trees don't match")
+			}
+
+			errs[i] = err
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("reader %d: unable to read the tree: %v", i, err)
+		}
+	}
+}
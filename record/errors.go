@@ -0,0 +1,42 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when the number of a record is not in the
+	// record table of its segment.
+	ErrNotFound = errors.New("record not found")
+	// ErrOutOfBounds is returned when the offset of a record points outside
+	// of the data of its segment.
+	ErrOutOfBounds = errors.New("record out of bounds")
+	// ErrTruncated is returned when a record ends before its content does.
+	ErrTruncated = errors.New("truncated record")
+	// ErrInvalidRecord is returned when the header of a record is not valid
+	// for the type of record being read.
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrInvalidReference is returned when a record ID refers to a segment
+	// that is not in the reference table of the segment being read.
+	ErrInvalidReference = errors.New("invalid segment reference")
+)
+
+// RecordError describes why a record couldn't be read. Err is one of the errors
+// defined by this package and can be tested with errors.Is. ID is the record
+// being read, and Offset is the position in its segment of the field that
+// failed validation, or -1 if the record couldn't be located.
+type RecordError struct {
+	Err    error
+	ID     ID
+	Offset int
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%v %v at offset %d", e.Err, e.ID, e.Offset)
+}
+
+// Unwrap returns Err.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,825 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"../segment"
+)
+
+// Reader reads records from the segments returned by a function. The most
+// recently used segments are kept in memory, so that they are not read again
+// by the following reads. A reader can be used by more than one goroutine at a
+// time.
+type Reader struct {
+	read     func(msb, lsb uint64) ([]byte, error)
+	segments *segmentCache
+	trace    func(id ID)
+}
+
+type readerSegment struct {
+	msb        uint64
+	lsb        uint64
+	data       []byte
+	bulk       bool
+	references []segment.Reference
+	records    map[int]segment.Record
+	sizes      map[int]int
+}
+
+// NodeRecord is a node read from a node record. Properties are in the order
+// they are stored, with the primary type and the mixin types first.
+type NodeRecord struct {
+	ID         ID
+	StableID   ID
+	Template   ID
+	Children   []ChildRecord
+	Properties []PropertyRecord
+}
+
+// ChildRecord is a named child of a node record.
+type ChildRecord struct {
+	Name string
+	ID   ID
+}
+
+// PropertyRecord is a property of a node record. Values are the IDs of the
+// records containing the values of the property.
+type PropertyRecord struct {
+	Name   string
+	Type   Type
+	Array  bool
+	Values []ID
+}
+
+// NewReader creates a reader keeping up to DefaultCacheSize bytes of segments
+// in memory. The content of the segments is returned by 'read', given the ID
+// of the segment.
+func NewReader(read func(msb, lsb uint64) ([]byte, error)) *Reader {
+	return NewReaderSize(read, DefaultCacheSize)
+}
+
+// NewReaderSize creates a reader keeping up to 'size' bytes of segments in
+// memory. The most recently used segment is kept even if it is bigger than
+// 'size'. The content of the segments is returned by 'read', given the ID of
+// the segment. 'read' can be called by more than one goroutine at a time.
+func NewReaderSize(read func(msb, lsb uint64) ([]byte, error), size int) *Reader {
+	return &Reader{
+		read:     read,
+		segments: newSegmentCache(size),
+	}
+}
+
+// Trace returns a reader calling 'f' with the ID of every record it decodes,
+// including the templates, lists, maps and blocks read on the way. A record
+// can be reported more than once. The returned reader shares the segments in
+// memory with 'r', and 'r' is not traced.
+func (r *Reader) Trace(f func(id ID)) *Reader {
+	return &Reader{
+		read:     r.read,
+		segments: r.segments,
+		trace:    f,
+	}
+}
+
+// Size returns the estimated size of the record identified by 'id'. See
+// segment.RecordSizes for how the size of a record is estimated. Records in
+// bulk segments are blocks of at most blockSize bytes.
+func (r *Reader) Size(id ID) (int, error) {
+	s, err := r.segment(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.bulk {
+		if size := segment.MaxSize - id.Number; size < blockSize {
+			return size, nil
+		}
+		return blockSize, nil
+	}
+
+	size, ok := s.sizes[id.Number]
+	if !ok {
+		return 0, &RecordError{Err: ErrNotFound, ID: id, Offset: -1}
+	}
+
+	return size, nil
+}
+
+// Type returns the type of the record identified by 'id'. Records in bulk
+// segments are always blocks.
+func (r *Reader) Type(id ID) (segment.RecordType, error) {
+	s, err := r.segment(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.bulk {
+		return segment.RecordTypeBlock, nil
+	}
+
+	record, ok := s.records[id.Number]
+	if !ok {
+		return 0, &RecordError{Err: ErrNotFound, ID: id, Offset: -1}
+	}
+
+	return record.Type, nil
+}
+
+// ReadNode reads the node record identified by 'id'.
+func (r *Reader) ReadNode(id ID) (*NodeRecord, error) {
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &NodeRecord{ID: id}
+
+	if n.StableID, err = d.readID(); err != nil {
+		return nil, err
+	}
+
+	if n.Template, err = d.readID(); err != nil {
+		return nil, err
+	}
+
+	t, err := r.readTemplate(n.Template)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template %v: %w", n.Template, err)
+	}
+
+	n.Properties = append(n.Properties, t.types...)
+
+	switch {
+	case t.childName != "":
+		cid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		n.Children = []ChildRecord{{Name: t.childName, ID: cid}}
+	case t.manyChildren:
+		mid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		if n.Children, err = r.readMap(mid); err != nil {
+			return nil, fmt.Errorf("unable to read map %v: %w", mid, err)
+		}
+	}
+
+	if len(t.properties) == 0 {
+		return n, nil
+	}
+
+	lid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.readList(lid, len(t.properties))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the properties: %w", err)
+	}
+
+	for i, p := range t.properties {
+		if !p.Array {
+			p.Values = []ID{values[i]}
+		} else if p.Values, err = r.readListRecord(values[i]); err != nil {
+			return nil, fmt.Errorf("unable to read property %s: %w", p.Name, err)
+		}
+		n.Properties = append(n.Properties, p)
+	}
+
+	return n, nil
+}
+
+// ReadString reads the string stored in the value record identified by 'id'.
+func (r *Reader) ReadString(id ID) (string, error) {
+	var b bytes.Buffer
+	if _, err := r.WriteValueTo(id, &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ReadBlobID reads the blob ID stored in the record identified by 'id'.
+func (r *Reader) ReadBlobID(id ID) (string, error) {
+	d, err := r.decoder(id)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := d.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	if head == 0xf0 {
+		sid, err := d.readID()
+		if err != nil {
+			return "", err
+		}
+		return r.ReadString(sid)
+	}
+
+	if head&0xf0 != 0xe0 {
+		return "", &RecordError{Err: ErrInvalidRecord, ID: id, Offset: d.start}
+	}
+
+	low, err := d.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := d.readBytes(int(head&0x0f)<<8 | int(low))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// ReadValue reads the value of a property of type 't' stored in the record
+// identified by 'id'. Binary values stored in blob ID records are returned as
+// blob IDs, every other binary value is read in memory.
+func (r *Reader) ReadValue(id ID, t Type) (Value, error) {
+	if t == TypeBinary {
+		rt, err := r.Type(id)
+		if err != nil {
+			return Value{}, err
+		}
+		if rt == segment.RecordTypeBlobID {
+			blobID, err := r.ReadBlobID(id)
+			return Value{BlobID: blobID}, err
+		}
+		var b bytes.Buffer
+		if _, err := r.WriteValueTo(id, &b); err != nil {
+			return Value{}, err
+		}
+		return Value{Binary: b.Bytes()}, nil
+	}
+
+	s, err := r.ReadString(id)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{String: s}, nil
+}
+
+// WriteValueTo writes the content of the value record identified by 'id' to
+// 'w'. Long values are read one block at a time. It returns the number of bytes
+// written and an optional error.
+func (r *Reader) WriteValueTo(id ID, w io.Writer) (int64, error) {
+	d, length, blocks, err := r.readValueHead(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if blocks == nil {
+		data, err := d.readBytes(length)
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+
+	var written int64
+
+	for _, b := range blocks {
+		n := length - int(written)
+
+		if n > blockSize {
+			n = blockSize
+		}
+
+		bd, err := r.decoder(b)
+		if err != nil {
+			return written, err
+		}
+
+		data, err := bd.readBytes(n)
+		if err != nil {
+			return written, err
+		}
+
+		m, err := w.Write(data)
+		written += int64(m)
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// ValueLength returns the length of the value stored in the value record
+// identified by 'id'. Only the header of the record is read, so the blocks of
+// long values are not read.
+func (r *Reader) ValueLength(id ID) (int, error) {
+	_, length, _, err := r.readValueLength(id)
+	return length, err
+}
+
+// readValueHead reads the length of the value record identified by 'id', and
+// returns a decoder positioned at the beginning of the data. Long values are
+// not stored inline, and the IDs of their blocks are returned instead.
+func (r *Reader) readValueHead(id ID) (*decoder, int, []ID, error) {
+	d, length, long, err := r.readValueLength(id)
+	if err != nil || !long {
+		return d, length, nil, err
+	}
+
+	lid, err := d.readID()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	blocks, err := r.readList(lid, (length+blockSize-1)/blockSize)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return d, length, blocks, nil
+}
+
+// readValueLength reads the length of the value record identified by 'id', and
+// returns a decoder positioned after it. If the value is long, the decoder is
+// positioned at the ID of the list of its blocks.
+func (r *Reader) readValueLength(id ID) (*decoder, int, bool, error) {
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	head, err := d.readByte()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	switch {
+	case head&0x80 == 0:
+		return d, int(head), false, nil
+	case head&0xc0 == 0x80:
+		low, err := d.readByte()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return d, (int(head&0x3f)<<8 | int(low)) + smallLimit, false, nil
+	case head&0xe0 == 0xc0:
+		d.pos--
+		v, err := d.readLong()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return d, int(v&0x3fffffffffffffff) + mediumLimit, true, nil
+	default:
+		return nil, 0, false, &RecordError{Err: ErrInvalidRecord, ID: id, Offset: d.start}
+	}
+}
+
+type template struct {
+	types        []PropertyRecord
+	childName    string
+	manyChildren bool
+	properties   []PropertyRecord
+}
+
+func (r *Reader) readTemplate(id ID) (*template, error) {
+	const (
+		primaryTypeBit  = 1 << 31
+		mixinTypesBit   = 1 << 30
+		zeroChildrenBit = 1 << 29
+		manyChildrenBit = 1 << 28
+	)
+
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &template{manyChildren: head&manyChildrenBit != 0}
+
+	if head&primaryTypeBit != 0 {
+		pid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		t.types = append(t.types, PropertyRecord{
+			Name:   "jcr:primaryType",
+			Type:   TypeName,
+			Values: []ID{pid},
+		})
+	}
+
+	if head&mixinTypesBit != 0 {
+		p := PropertyRecord{Name: "jcr:mixinTypes", Type: TypeName, Array: true}
+		for i := 0; i < int(head>>18&0x3ff); i++ {
+			mid, err := d.readID()
+			if err != nil {
+				return nil, err
+			}
+			p.Values = append(p.Values, mid)
+		}
+		t.types = append(t.types, p)
+	}
+
+	if head&(zeroChildrenBit|manyChildrenBit) == 0 {
+		cid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		if t.childName, err = r.ReadString(cid); err != nil {
+			return nil, err
+		}
+	}
+
+	n := int(head & 0x3ffff)
+
+	if n == 0 {
+		return t, nil
+	}
+
+	lid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := r.readList(lid, n)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nid := range names {
+		name, err := r.ReadString(nid)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		p := PropertyRecord{Name: name, Type: Type(int8(b))}
+
+		if p.Type < 0 {
+			p.Type, p.Array = -p.Type, true
+		}
+
+		t.properties = append(t.properties, p)
+	}
+
+	return t, nil
+}
+
+// readListRecord reads a list record, made of the number of elements and the
+// ID of the list.
+func (r *Reader) readListRecord(id ID) ([]ID, error) {
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	lid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.readList(lid, int(n))
+}
+
+// readList reads the elements of the list whose root is 'id'. The list is a
+// tree of buckets, where every bucket has at most listLevelSize elements. A
+// list with a single element is represented by the element itself.
+func (r *Reader) readList(id ID, n int) ([]ID, error) {
+	if n == 1 {
+		return []ID{id}, nil
+	}
+
+	size := 1
+
+	for size*listLevelSize < n {
+		size *= listLevelSize
+	}
+
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []ID
+
+	for i := 0; i < n; i += size {
+		bid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+
+		m := n - i
+
+		if m > size {
+			m = size
+		}
+
+		if size == 1 {
+			ids = append(ids, bid)
+			continue
+		}
+
+		bucket, err := r.readList(bid, m)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, bucket...)
+	}
+
+	return ids, nil
+}
+
+// readMap reads the entries of the map whose root is 'id'. Entries are returned
+// in the order they are stored.
+func (r *Reader) readMap(id ID) ([]ChildRecord, error) {
+	d, err := r.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	if head == mapDiffHead {
+		return r.readMapDiff(d)
+	}
+
+	var (
+		level = int(head >> mapSizeBits)
+		size  = int(head & (1<<mapSizeBits - 1))
+	)
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	if size > mapBucketsPerLevel && level < mapMaxLevels-1 {
+		bitmap, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []ChildRecord
+
+		for i := 0; i < bits.OnesCount32(bitmap); i++ {
+			bid, err := d.readID()
+			if err != nil {
+				return nil, err
+			}
+			bucket, err := r.readMap(bid)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, bucket...)
+		}
+
+		return entries, nil
+	}
+
+	if _, err := d.readBytes(size * 4); err != nil {
+		return nil, err
+	}
+
+	entries := make([]ChildRecord, size)
+
+	for i := range entries {
+		kid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		vid, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+		name, err := r.ReadString(kid)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = ChildRecord{Name: name, ID: vid}
+	}
+
+	return entries, nil
+}
+
+// readMapDiff reads the entries of a map diff record, given a decoder
+// positioned after its head. The record is made of the hash of the key of the
+// changed entry, the IDs of the key and of the new value, and the ID of the
+// base map. The entry replaces the one with the same key in the base map, or
+// is added after its entries.
+func (r *Reader) readMapDiff(d *decoder) ([]ChildRecord, error) {
+	if _, err := d.readInt(); err != nil {
+		return nil, err
+	}
+
+	kid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	vid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	bid, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := r.ReadString(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.readMap(bid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read base map %v: %w", bid, err)
+	}
+
+	for i := range entries {
+		if entries[i].Name == name {
+			entries[i].ID = vid
+			return entries, nil
+		}
+	}
+
+	return append(entries, ChildRecord{Name: name, ID: vid}), nil
+}
+
+func (r *Reader) segment(id ID) (*readerSegment, error) {
+	key := [2]uint64{id.Msb, id.Lsb}
+
+	if s := r.segments.get(key); s != nil {
+		return s, nil
+	}
+
+	data, err := r.read(id.Msb, id.Lsb)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &readerSegment{
+		msb:  id.Msb,
+		lsb:  id.Lsb,
+		data: data,
+		bulk: id.Lsb>>60 == 0xb,
+	}
+
+	if !s.bulk {
+		var sg segment.Segment
+
+		if _, err := sg.ReadFrom(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("unable to read segment %016x%016x: %w", id.Msb, id.Lsb, err)
+		}
+
+		s.references = sg.References
+		s.records = make(map[int]segment.Record, len(sg.Records))
+
+		for _, record := range sg.Records {
+			s.records[record.Number] = record
+		}
+
+		s.sizes = sg.RecordSizes()
+	}
+
+	return r.segments.add(key, s), nil
+}
+
+// decoder returns a decoder positioned at the beginning of the record
+// identified by 'id'. The offsets of records are relative to the end of a
+// segment of the maximum size. Records in bulk segments are numbered after
+// their offset.
+func (r *Reader) decoder(id ID) (*decoder, error) {
+	s, err := r.segment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := id.Number
+
+	if !s.bulk {
+		record, ok := s.records[id.Number]
+		if !ok {
+			return nil, &RecordError{Err: ErrNotFound, ID: id, Offset: -1}
+		}
+		offset = record.Offset
+	}
+
+	pos := len(s.data) - (segment.MaxSize - offset)
+
+	if pos < 0 || pos >= len(s.data) {
+		return nil, &RecordError{Err: ErrOutOfBounds, ID: id, Offset: -1}
+	}
+
+	if r.trace != nil {
+		r.trace(id)
+	}
+
+	return &decoder{segment: s, id: id, start: pos, pos: pos}, nil
+}
+
+type decoder struct {
+	segment *readerSegment
+	id      ID
+	start   int
+	pos     int
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.segment.data) {
+		return nil, &RecordError{Err: ErrTruncated, ID: d.id, Offset: d.pos}
+	}
+	data := d.segment.data[d.pos : d.pos+n]
+	d.pos += n
+	return data, nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	data, err := d.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+func (d *decoder) readShort() (uint16, error) {
+	data, err := d.readBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(data), nil
+}
+
+func (d *decoder) readInt() (uint32, error) {
+	data, err := d.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+func (d *decoder) readLong() (uint64, error) {
+	data, err := d.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// readID reads a record ID. The segment of the record is either the segment
+// being read, or one of the segments in its reference table.
+func (d *decoder) readID() (ID, error) {
+	offset := d.pos
+
+	reference, err := d.readShort()
+	if err != nil {
+		return ID{}, err
+	}
+
+	number, err := d.readInt()
+	if err != nil {
+		return ID{}, err
+	}
+
+	if reference == 0 {
+		return ID{Msb: d.segment.msb, Lsb: d.segment.lsb, Number: int(number)}, nil
+	}
+
+	if int(reference) > len(d.segment.references) {
+		return ID{}, &RecordError{Err: ErrInvalidReference, ID: d.id, Offset: offset}
+	}
+
+	s := d.segment.references[reference-1]
+
+	return ID{Msb: s.Msb, Lsb: s.Lsb, Number: int(number)}, nil
+}
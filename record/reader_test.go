@@ -0,0 +1,174 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"../segment"
+)
+
+func TestReaderErrors(t *testing.T) {
+	const (
+		msb = 1
+		lsb = 0xa000000000000002
+	)
+
+	builder := &segment.Builder{Version: 13}
+
+	records := [][]byte{
+		// A medium value whose length goes past the end of the segment.
+		{0x80, 0x01},
+		// A value record with an invalid header.
+		{0xe0},
+		// A list of two elements whose ID refers to a missing reference.
+		{0, 0, 0, 2, 0, 5, 0, 0, 0, 0},
+	}
+
+	for _, data := range records {
+		if _, err := builder.AddRecord(segment.RecordTypeValue, data); err != nil {
+			t.Fatalf("unable to add the record: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+
+	if _, err := builder.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	r := NewReader(memoryStore{{msb, lsb}: b.Bytes()}.read)
+
+	id := func(number int) ID {
+		return ID{Msb: msb, Lsb: lsb, Number: number}
+	}
+
+	tests := []struct {
+		name string
+		read func() error
+		err  error
+		id   ID
+	}{
+		{"not found", func() error { _, err := r.ReadNode(id(9)); return err }, ErrNotFound, id(9)},
+		{"truncated", func() error { _, err := r.ReadString(id(0)); return err }, ErrTruncated, id(0)},
+		{"invalid", func() error { _, err := r.ReadString(id(1)); return err }, ErrInvalidRecord, id(1)},
+		{"reference", func() error { _, err := r.readListRecord(id(2)); return err }, ErrInvalidReference, id(2)},
+	}
+
+	for _, test := range tests {
+		err := test.read()
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: unexpected error %v, want %v", test.name, err, test.err)
+		}
+
+		var re *RecordError
+
+		if !errors.As(err, &re) {
+			t.Fatalf("%s: unexpected error type %T", test.name, err)
+		}
+
+		if re.ID != test.id {
+			t.Fatalf("%s: unexpected record %v, want %v", test.name, re.ID, test.id)
+		}
+	}
+}
+
+func TestReaderSegmentError(t *testing.T) {
+	id := ID{Msb: 1, Lsb: 0xa000000000000002}
+
+	r := NewReader(memoryStore{{id.Msb, id.Lsb}: []byte("0aK")}.read)
+
+	_, err := r.ReadNode(id)
+
+	var pe *segment.ParseError
+
+	if !errors.As(err, &pe) || !errors.Is(err, segment.ErrTruncated) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestReadMapDiff(t *testing.T) {
+	var (
+		m        = make(memoryStore)
+		w        = NewWriter(rand.New(rand.NewSource(1)), m.flush)
+		children []Child
+	)
+
+	// The base map has more entries than a map leaf can hold, like the maps
+	// Oak writes diff records for.
+
+	for i := 0; i < 40; i++ {
+		children = append(children, Child{Name: "child" + strconv.Itoa(i), Node: &Node{}})
+	}
+
+	base, err := w.writeMap(children)
+	if err != nil {
+		t.Fatalf("unable to write the map: %v", err)
+	}
+
+	value, err := w.WriteNode(&Node{Properties: []Property{{Name: "changed", Type: TypeBoolean, Values: []Value{{String: "true"}}}}})
+	if err != nil {
+		t.Fatalf("unable to write the node: %v", err)
+	}
+
+	writeDiff := func(name string, base ID) ID {
+		key, err := w.writeString(name)
+		if err != nil {
+			t.Fatalf("unable to write the key: %v", err)
+		}
+
+		id, err := w.writeRecord(segment.RecordTypeMapBranch, 8+3*idSize, []ID{key, value, base}, func(e *encoder) {
+			e.writeInt(mapDiffHead)
+			e.writeInt(uint32(javaHash(name)))
+			e.writeID(key)
+			e.writeID(value)
+			e.writeID(base)
+		})
+		if err != nil {
+			t.Fatalf("unable to write the diff: %v", err)
+		}
+
+		return id
+	}
+
+	var (
+		changed = writeDiff("child7", base)
+		added   = writeDiff("extra", changed)
+	)
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	r := NewReader(m.read)
+
+	want, err := r.readMap(base)
+	if err != nil {
+		t.Fatalf("unable to read the base map: %v", err)
+	}
+
+	if len(want) != len(children) {
+		t.Fatalf("unexpected number of entries: got %d, want %d", len(want), len(children))
+	}
+
+	for i := range want {
+		if want[i].Name == "child7" {
+			want[i].ID = value
+		}
+	}
+
+	want = append(want, ChildRecord{Name: "extra", ID: value})
+
+	got, err := r.readMap(added)
+	if err != nil {
+		t.Fatalf("unable to read the diff: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries:\n%v\n%v", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+package record
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// idSize is the size of a serialized record ID: the number of the
+	// segment reference and the record number.
+	idSize = 6
+
+	// smallLimit is the size limit of values whose length fits in one byte.
+	smallLimit = 1 << 7
+
+	// mediumLimit is the size limit of values whose length fits in two bytes.
+	// Longer values are stored in blocks.
+	mediumLimit = 1<<14 + smallLimit
+
+	// blobIDSmallLimit is the size limit of blob IDs stored inline.
+	blobIDSmallLimit = 1 << 12
+
+	// blockSize is the size of the blocks used to store long values.
+	blockSize = 4096
+
+	// listLevelSize is the number of elements in a list bucket.
+	listLevelSize = 1 << 8
+
+	// mapBitsPerLevel is the number of bits of the hash used at every level of
+	// a map.
+	mapBitsPerLevel = 5
+
+	// mapBucketsPerLevel is the number of buckets in a map branch.
+	mapBucketsPerLevel = 1 << mapBitsPerLevel
+
+	// mapMaxLevels is the maximum number of levels in a map.
+	mapMaxLevels = (32 + mapBitsPerLevel - 1) / mapBitsPerLevel
+
+	// mapSizeBits is the number of bits used for the size of a map.
+	mapSizeBits = 28
+
+	// mapDiffHead is the head of a map diff record, which changes the value
+	// of a single entry of a base map.
+	mapDiffHead = 0xffffffff
+)
+
+// ID identifies a record by the segment it belongs to and its number in that
+// segment.
+type ID struct {
+	Msb    uint64
+	Lsb    uint64
+	Number int
+}
+
+var idRegexp = regexp.MustCompile("^([0-9a-f]{8})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{12})(?::([0-9]+)|\\.([0-9a-f]{8}))$")
+
+// ParseID parses a record ID. Both the format used by the journal, where the
+// record number is in decimal notation and separated by a colon, and the one
+// where the record number is in hexadecimal notation and separated by a dot are
+// supported.
+func ParseID(s string) (ID, error) {
+	m := idRegexp.FindStringSubmatch(s)
+
+	if m == nil {
+		return ID{}, fmt.Errorf("invalid record ID '%s'", s)
+	}
+
+	var (
+		msb, _ = strconv.ParseUint(m[1]+m[2]+m[3], 16, 64)
+		lsb, _ = strconv.ParseUint(m[4]+m[5], 16, 64)
+		number uint64
+		err    error
+	)
+
+	if m[6] != "" {
+		number, err = strconv.ParseUint(m[6], 10, 32)
+	} else {
+		number, err = strconv.ParseUint(m[7], 16, 32)
+	}
+
+	if err != nil {
+		return ID{}, fmt.Errorf("invalid record number in '%s'", s)
+	}
+
+	return ID{Msb: msb, Lsb: lsb, Number: int(number)}, nil
+}
+
+// String returns the representation of the record ID with the record number in
+// hexadecimal notation.
+func (id ID) String() string {
+	return fmt.Sprintf("%s.%08x", uuid(id.Msb, id.Lsb), id.Number)
+}
+
+// Journal returns the representation of the record ID used by the journal.
+func (id ID) Journal() string {
+	return fmt.Sprintf("%s:%d", uuid(id.Msb, id.Lsb), id.Number)
+}
+
+// A Type is the type of a property.
+type Type int
+
+const (
+	// TypeString is the type of string properties.
+	TypeString Type = iota + 1
+	// TypeBinary is the type of binary properties.
+	TypeBinary
+	// TypeLong is the type of long properties.
+	TypeLong
+	// TypeDouble is the type of double properties.
+	TypeDouble
+	// TypeDate is the type of date properties.
+	TypeDate
+	// TypeBoolean is the type of boolean properties.
+	TypeBoolean
+	// TypeName is the type of name properties.
+	TypeName
+	// TypePath is the type of path properties.
+	TypePath
+	// TypeReference is the type of reference properties.
+	TypeReference
+	// TypeWeakReference is the type of weak reference properties.
+	TypeWeakReference
+	// TypeURI is the type of URI properties.
+	TypeURI
+	// TypeDecimal is the type of decimal properties.
+	TypeDecimal
+)
+
+var typeNames = []string{
+	TypeString:        "String",
+	TypeBinary:        "Binary",
+	TypeLong:          "Long",
+	TypeDouble:        "Double",
+	TypeDate:          "Date",
+	TypeBoolean:       "Boolean",
+	TypeName:          "Name",
+	TypePath:          "Path",
+	TypeReference:     "Reference",
+	TypeWeakReference: "WeakReference",
+	TypeURI:           "URI",
+	TypeDecimal:       "Decimal",
+}
+
+// ParseType returns the type with the given name, as returned by String.
+func ParseType(s string) (Type, error) {
+	for t, n := range typeNames {
+		if n != "" && n == s {
+			return Type(t), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid type '%s'", s)
+}
+
+func (t Type) String() string {
+	if t > 0 && int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "Undefined"
+}
+
+// Node is a node of a content tree, made of properties and child nodes.
+type Node struct {
+	Properties []Property
+	Children   []Child
+}
+
+// Child is a named child of a node.
+type Child struct {
+	Name string
+	Node *Node
+}
+
+// Property is a named property of a node.
+type Property struct {
+	Name   string
+	Type   Type
+	Array  bool
+	Values []Value
+}
+
+// Value is the value of a property. Binary values are either stored inline in
+// the segments or referenced by the ID of an external blob. Every other value
+// is stored as a string.
+type Value struct {
+	String string
+	Binary []byte
+	BlobID string
+}
+
+// javaHash returns the same hash code that Java computes for 's'. The hash code
+// is used to sort the entries of maps and the properties of templates.
+func javaHash(s string) int32 {
+	var h int32
+	for _, c := range s {
+		if c >= 0x10000 {
+			c -= 0x10000
+			h = 31*h + int32(0xd800+(c>>10))
+			h = 31*h + int32(0xdc00+(c&0x3ff))
+		} else {
+			h = 31*h + int32(c)
+		}
+	}
+	return h
+}
+
+func uuid(msb, lsb uint64) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", msb>>32, (msb>>16)&0xffff, msb&0xffff, lsb>>48, lsb&0xffffffffffff)
+}
@@ -0,0 +1,784 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"../segment"
+)
+
+// Segment is a segment produced by a Writer, together with the references to
+// external binaries from its records.
+type Segment struct {
+	Msb            uint64
+	Lsb            uint64
+	Generation     int
+	FullGeneration int
+	Compacted      bool
+	Data           []byte
+	Binaries       []string
+}
+
+// Writer writes content trees as records in data and bulk segments. A segment
+// is passed to the flush function as soon as it is complete, so that a segment
+// is always flushed after the segments it references.
+type Writer struct {
+	// Generation, FullGeneration and Compacted are written in the header of
+	// every segment. Changing them only affects segments created afterwards,
+	// so the writer should be flushed before.
+	Generation     int
+	FullGeneration int
+	Compacted      bool
+
+	// Time is the time written in the segment info record of every new
+	// segment.
+	Time time.Time
+
+	rand     *rand.Rand
+	flush    func(s *Segment) error
+	builder  *segment.Builder
+	msb      uint64
+	lsb      uint64
+	records  int
+	refs     map[[2]uint64]bool
+	binaries []string
+	sno      int
+	strings  map[string]ID
+	tmpls    map[string]ID
+	nodes    map[*Node]ID
+}
+
+// NewWriter creates a writer. The segment IDs are generated from 'r' and every
+// complete segment is passed to 'flush'.
+func NewWriter(r *rand.Rand, flush func(s *Segment) error) *Writer {
+	w := &Writer{rand: r, flush: flush}
+	w.Reset()
+	return w
+}
+
+// Reset clears the caches of the writer, so that records already written are
+// not reused by records written afterwards. This is needed when switching to a
+// new generation.
+func (w *Writer) Reset() {
+	w.strings = make(map[string]ID)
+	w.tmpls = make(map[string]ID)
+	w.nodes = make(map[*Node]ID)
+}
+
+// Flush flushes the current data segment, if any.
+func (w *Writer) Flush() error {
+	if w.builder == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+
+	if _, err := w.builder.WriteTo(&b); err != nil {
+		return err
+	}
+
+	s := &Segment{
+		Msb:            w.msb,
+		Lsb:            w.lsb,
+		Generation:     w.Generation,
+		FullGeneration: w.FullGeneration,
+		Compacted:      w.Compacted,
+		Data:           b.Bytes(),
+		Binaries:       w.binaries,
+	}
+
+	w.builder = nil
+	w.binaries = nil
+
+	return w.flush(s)
+}
+
+// WriteNode writes a node and its subtree and returns the ID of the node record.
+// Nodes already written by this writer are not written again. Nodes must not be
+// modified after they are written.
+func (w *Writer) WriteNode(n *Node) (ID, error) {
+	if id, ok := w.nodes[n]; ok {
+		return id, nil
+	}
+
+	var (
+		primaryType *Value
+		mixinTypes  []Value
+		hasMixins   bool
+		properties  []Property
+	)
+
+	for _, p := range n.Properties {
+		switch {
+		case p.Name == "jcr:primaryType" && p.Type == TypeName && !p.Array && len(p.Values) == 1:
+			primaryType = &p.Values[0]
+		case p.Name == "jcr:mixinTypes" && p.Type == TypeName && p.Array:
+			mixinTypes, hasMixins = p.Values, true
+		default:
+			properties = append(properties, p)
+		}
+	}
+
+	sort.Slice(properties, func(i, j int) bool {
+		a, b := properties[i].Name, properties[j].Name
+		if ha, hb := javaHash(a), javaHash(b); ha != hb {
+			return ha < hb
+		}
+		return a < b
+	})
+
+	var childName *string
+
+	if len(n.Children) == 1 {
+		childName = &n.Children[0].Name
+	}
+
+	tid, err := w.writeTemplate(primaryType, mixinTypes, hasMixins, len(n.Children), childName, properties)
+	if err != nil {
+		return ID{}, err
+	}
+
+	ids := []ID{tid}
+
+	if len(n.Children) == 1 {
+		cid, err := w.WriteNode(n.Children[0].Node)
+		if err != nil {
+			return ID{}, err
+		}
+		ids = append(ids, cid)
+	}
+
+	if len(n.Children) > 1 {
+		mid, err := w.writeMap(n.Children)
+		if err != nil {
+			return ID{}, err
+		}
+		ids = append(ids, mid)
+	}
+
+	if len(properties) > 0 {
+		var pids []ID
+
+		for _, p := range properties {
+			pid, err := w.writeProperty(p)
+			if err != nil {
+				return ID{}, err
+			}
+			pids = append(pids, pid)
+		}
+
+		lid, err := w.writeList(pids)
+		if err != nil {
+			return ID{}, err
+		}
+
+		ids = append(ids, lid)
+	}
+
+	id, err := w.writeRecord(segment.RecordTypeNode, (len(ids)+1)*idSize, ids, func(e *encoder) {
+		// A new node uses its own ID as its stable ID.
+		e.writeID(e.self)
+		for _, id := range ids {
+			e.writeID(id)
+		}
+	})
+	if err != nil {
+		return ID{}, err
+	}
+
+	w.nodes[n] = id
+
+	return id, nil
+}
+
+func (w *Writer) writeTemplate(primaryType *Value, mixinTypes []Value, hasMixins bool, nchildren int, childName *string, properties []Property) (ID, error) {
+	var key bytes.Buffer
+
+	if primaryType != nil {
+		fmt.Fprintf(&key, "p%q", primaryType.String)
+	}
+	if hasMixins {
+		fmt.Fprintf(&key, "m%d", len(mixinTypes))
+		for _, m := range mixinTypes {
+			fmt.Fprintf(&key, "%q", m.String)
+		}
+	}
+	if childName != nil {
+		fmt.Fprintf(&key, "c%q", *childName)
+	} else {
+		fmt.Fprintf(&key, "c%v", nchildren > 1)
+	}
+	for _, p := range properties {
+		fmt.Fprintf(&key, "%q%d%v", p.Name, p.Type, p.Array)
+	}
+
+	if id, ok := w.tmpls[key.String()]; ok {
+		return id, nil
+	}
+
+	var (
+		head uint32
+		ids  []ID
+	)
+
+	if primaryType != nil {
+		id, err := w.writeString(primaryType.String)
+		if err != nil {
+			return ID{}, err
+		}
+		head |= 1 << 31
+		ids = append(ids, id)
+	}
+
+	if hasMixins {
+		for _, m := range mixinTypes {
+			id, err := w.writeString(m.String)
+			if err != nil {
+				return ID{}, err
+			}
+			ids = append(ids, id)
+		}
+		head |= 1<<30 | uint32(len(mixinTypes))<<18
+	}
+
+	if nchildren == 0 {
+		head |= 1 << 29
+	}
+
+	if nchildren > 1 {
+		head |= 1 << 28
+	}
+
+	if childName != nil {
+		id, err := w.writeString(*childName)
+		if err != nil {
+			return ID{}, err
+		}
+		ids = append(ids, id)
+	}
+
+	head |= uint32(len(properties))
+
+	if len(properties) > 0 {
+		var names []ID
+
+		for _, p := range properties {
+			id, err := w.writeString(p.Name)
+			if err != nil {
+				return ID{}, err
+			}
+			names = append(names, id)
+		}
+
+		lid, err := w.writeList(names)
+		if err != nil {
+			return ID{}, err
+		}
+
+		ids = append(ids, lid)
+	}
+
+	id, err := w.writeRecord(segment.RecordTypeTemplate, 4+len(ids)*idSize+len(properties), ids, func(e *encoder) {
+		e.writeInt(head)
+		for _, id := range ids {
+			e.writeID(id)
+		}
+		for _, p := range properties {
+			t := int8(p.Type)
+			if p.Array {
+				t = -t
+			}
+			e.writeByte(byte(t))
+		}
+	})
+	if err != nil {
+		return ID{}, err
+	}
+
+	w.tmpls[key.String()] = id
+
+	return id, nil
+}
+
+func (w *Writer) writeProperty(p Property) (ID, error) {
+	var ids []ID
+
+	for _, v := range p.Values {
+		var (
+			id  ID
+			err error
+		)
+		if p.Type == TypeBinary {
+			id, err = w.writeBinary(v)
+		} else {
+			id, err = w.writeString(v.String)
+		}
+		if err != nil {
+			return ID{}, err
+		}
+		ids = append(ids, id)
+	}
+
+	if !p.Array {
+		if len(ids) != 1 {
+			return ID{}, fmt.Errorf("single-valued property %s with %d values", p.Name, len(ids))
+		}
+		return ids[0], nil
+	}
+
+	if len(ids) == 0 {
+		return w.writeRecord(segment.RecordTypeList, 4, nil, func(e *encoder) {
+			e.writeInt(0)
+		})
+	}
+
+	lid, err := w.writeList(ids)
+	if err != nil {
+		return ID{}, err
+	}
+
+	return w.writeRecord(segment.RecordTypeList, 4+idSize, []ID{lid}, func(e *encoder) {
+		e.writeInt(uint32(len(ids)))
+		e.writeID(lid)
+	})
+}
+
+type mapEntry struct {
+	hash  uint32
+	name  string
+	key   ID
+	value ID
+}
+
+func (w *Writer) writeMap(children []Child) (ID, error) {
+	var entries []mapEntry
+
+	for _, c := range children {
+		key, err := w.writeString(c.Name)
+		if err != nil {
+			return ID{}, err
+		}
+		value, err := w.WriteNode(c.Node)
+		if err != nil {
+			return ID{}, err
+		}
+		entries = append(entries, mapEntry{uint32(javaHash(c.Name)), c.Name, key, value})
+	}
+
+	return w.writeMapBucket(entries, 0)
+}
+
+func (w *Writer) writeMapBucket(entries []mapEntry, level int) (ID, error) {
+	if len(entries) <= mapBucketsPerLevel || level >= mapMaxLevels-1 {
+		return w.writeMapLeaf(entries, level)
+	}
+
+	var (
+		buckets [mapBucketsPerLevel][]mapEntry
+		shift   = uint(32 - (level+1)*mapBitsPerLevel)
+	)
+
+	for _, e := range entries {
+		i := (e.hash >> shift) & (mapBucketsPerLevel - 1)
+		buckets[i] = append(buckets[i], e)
+	}
+
+	var (
+		bitmap uint32
+		ids    []ID
+	)
+
+	for i, b := range buckets {
+		if len(b) == 0 {
+			continue
+		}
+		id, err := w.writeMapBucket(b, level+1)
+		if err != nil {
+			return ID{}, err
+		}
+		bitmap |= 1 << uint(i)
+		ids = append(ids, id)
+	}
+
+	return w.writeRecord(segment.RecordTypeMapBranch, 8+len(ids)*idSize, ids, func(e *encoder) {
+		e.writeInt(uint32(level)<<mapSizeBits | uint32(len(entries)))
+		e.writeInt(bitmap)
+		for _, id := range ids {
+			e.writeID(id)
+		}
+	})
+}
+
+func (w *Writer) writeMapLeaf(entries []mapEntry, level int) (ID, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].hash != entries[j].hash {
+			return entries[i].hash < entries[j].hash
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	var ids []ID
+
+	for _, e := range entries {
+		ids = append(ids, e.key, e.value)
+	}
+
+	return w.writeRecord(segment.RecordTypeMapLeaf, 4+len(entries)*4+len(ids)*idSize, ids, func(e *encoder) {
+		e.writeInt(uint32(level)<<mapSizeBits | uint32(len(entries)))
+		for _, entry := range entries {
+			e.writeInt(entry.hash)
+		}
+		for _, id := range ids {
+			e.writeID(id)
+		}
+	})
+}
+
+// writeList writes a list of record IDs as a tree of buckets and returns the ID
+// of the root of the tree. A list with a single element is represented by the
+// element itself.
+func (w *Writer) writeList(ids []ID) (ID, error) {
+	if len(ids) == 0 {
+		return ID{}, fmt.Errorf("empty list")
+	}
+
+	level := ids
+
+	for len(level) > 1 {
+		var next []ID
+
+		for i := 0; i < len(level); i += listLevelSize {
+			end := i + listLevelSize
+
+			if end > len(level) {
+				end = len(level)
+			}
+
+			bucket := level[i:end]
+
+			if len(bucket) == 1 {
+				next = append(next, bucket[0])
+				continue
+			}
+
+			id, err := w.writeRecord(segment.RecordTypeListBucket, len(bucket)*idSize, bucket, func(e *encoder) {
+				for _, id := range bucket {
+					e.writeID(id)
+				}
+			})
+			if err != nil {
+				return ID{}, err
+			}
+
+			next = append(next, id)
+		}
+
+		level = next
+	}
+
+	return level[0], nil
+}
+
+func (w *Writer) writeString(s string) (ID, error) {
+	cache := len(s) <= smallLimit
+
+	if cache {
+		if id, ok := w.strings[s]; ok {
+			return id, nil
+		}
+	}
+
+	data := []byte(s)
+
+	if len(data) >= mediumLimit {
+		var blocks []ID
+
+		for i := 0; i < len(data); i += blockSize {
+			end := i + blockSize
+
+			if end > len(data) {
+				end = len(data)
+			}
+
+			block := data[i:end]
+
+			id, err := w.writeRecord(segment.RecordTypeBlock, len(block), nil, func(e *encoder) {
+				e.writeBytes(block)
+			})
+			if err != nil {
+				return ID{}, err
+			}
+
+			blocks = append(blocks, id)
+		}
+
+		return w.writeLongValue(len(data), blocks)
+	}
+
+	id, err := w.writeValue(data)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if cache {
+		w.strings[s] = id
+	}
+
+	return id, nil
+}
+
+func (w *Writer) writeBinary(v Value) (ID, error) {
+	if v.BlobID != "" {
+		return w.writeBlobID(v.BlobID)
+	}
+
+	if len(v.Binary) < mediumLimit {
+		return w.writeValue(v.Binary)
+	}
+
+	var blocks []ID
+
+	for data := v.Binary; len(data) > 0; {
+		n := len(data)
+
+		if n > segment.MaxSize {
+			n = segment.MaxSize
+		}
+
+		var b segment.BulkBuilder
+
+		for i := 0; i < n; i += blockSize {
+			end := i + blockSize
+
+			if end > n {
+				end = n
+			}
+
+			if err := b.AddBlock(data[i:end]); err != nil {
+				return ID{}, err
+			}
+		}
+
+		msb, lsb := w.newSegmentID(0xb)
+
+		for _, r := range b.Records() {
+			blocks = append(blocks, ID{Msb: msb, Lsb: lsb, Number: r.Number})
+		}
+
+		var buffer bytes.Buffer
+
+		if _, err := b.WriteTo(&buffer); err != nil {
+			return ID{}, err
+		}
+
+		err := w.flush(&Segment{
+			Msb:            msb,
+			Lsb:            lsb,
+			Generation:     w.Generation,
+			FullGeneration: w.FullGeneration,
+			Compacted:      w.Compacted,
+			Data:           buffer.Bytes(),
+		})
+		if err != nil {
+			return ID{}, err
+		}
+
+		data = data[n:]
+	}
+
+	return w.writeLongValue(len(v.Binary), blocks)
+}
+
+func (w *Writer) writeBlobID(blobID string) (ID, error) {
+	var (
+		id  ID
+		err error
+	)
+
+	if data := []byte(blobID); len(data) < blobIDSmallLimit {
+		id, err = w.writeRecord(segment.RecordTypeBlobID, 2+len(data), nil, func(e *encoder) {
+			e.writeShort(uint16(len(data)) | 0xe000)
+			e.writeBytes(data)
+		})
+	} else {
+		var sid ID
+
+		if sid, err = w.writeString(blobID); err != nil {
+			return ID{}, err
+		}
+
+		id, err = w.writeRecord(segment.RecordTypeBlobID, 1+idSize, []ID{sid}, func(e *encoder) {
+			e.writeByte(0xf0)
+			e.writeID(sid)
+		})
+	}
+
+	if err != nil {
+		return ID{}, err
+	}
+
+	w.binaries = append(w.binaries, blobID)
+
+	return id, nil
+}
+
+func (w *Writer) writeValue(data []byte) (ID, error) {
+	if len(data) < smallLimit {
+		return w.writeRecord(segment.RecordTypeValue, 1+len(data), nil, func(e *encoder) {
+			e.writeByte(byte(len(data)))
+			e.writeBytes(data)
+		})
+	}
+
+	return w.writeRecord(segment.RecordTypeValue, 2+len(data), nil, func(e *encoder) {
+		e.writeShort(uint16(len(data)-smallLimit) | 0x8000)
+		e.writeBytes(data)
+	})
+}
+
+func (w *Writer) writeLongValue(length int, blocks []ID) (ID, error) {
+	lid, err := w.writeList(blocks)
+	if err != nil {
+		return ID{}, err
+	}
+
+	return w.writeRecord(segment.RecordTypeValue, 8+idSize, []ID{lid}, func(e *encoder) {
+		e.writeLong(uint64(length-mediumLimit) | 0x3<<62)
+		e.writeID(lid)
+	})
+}
+
+// writeRecord adds a record to the current data segment. If the record doesn't
+// fit, the current segment is flushed and the record is added to a new one. The
+// record is serialized by 'encode', since the serialized record IDs depend on
+// the segment the record is added to.
+func (w *Writer) writeRecord(t segment.RecordType, size int, ids []ID, encode func(e *encoder)) (ID, error) {
+	if w.builder != nil && !w.builder.Fits(size, w.newReferences(ids)) {
+		if err := w.Flush(); err != nil {
+			return ID{}, err
+		}
+	}
+
+	if w.builder == nil {
+		if err := w.newSegment(); err != nil {
+			return ID{}, err
+		}
+		if !w.builder.Fits(size, w.newReferences(ids)) {
+			return ID{}, fmt.Errorf("record too big")
+		}
+	}
+
+	e := encoder{
+		w:    w,
+		self: ID{Msb: w.msb, Lsb: w.lsb, Number: w.records},
+	}
+
+	encode(&e)
+
+	if len(e.data) != size {
+		return ID{}, fmt.Errorf("invalid record size: %d bytes declared, %d encoded", size, len(e.data))
+	}
+
+	n, err := w.builder.AddRecord(t, e.data)
+	if err != nil {
+		return ID{}, err
+	}
+
+	w.records++
+
+	return ID{Msb: w.msb, Lsb: w.lsb, Number: n}, nil
+}
+
+func (w *Writer) newReferences(ids []ID) int {
+	var (
+		n    int
+		seen = make(map[[2]uint64]bool)
+	)
+
+	for _, id := range ids {
+		k := [2]uint64{id.Msb, id.Lsb}
+		if id.Msb == w.msb && id.Lsb == w.lsb || w.refs[k] || seen[k] {
+			continue
+		}
+		seen[k] = true
+		n++
+	}
+
+	return n
+}
+
+func (w *Writer) newSegment() error {
+	w.msb, w.lsb = w.newSegmentID(0xa)
+	w.records = 0
+	w.refs = make(map[[2]uint64]bool)
+	w.builder = &segment.Builder{
+		Version:        13,
+		Generation:     w.Generation,
+		FullGeneration: w.FullGeneration,
+		Compacted:      w.Compacted,
+	}
+
+	info := fmt.Sprintf(`{"wid":"sys.%05d","sno":%d,"t":%d}`, 0, w.sno, w.Time.UnixNano()/int64(time.Millisecond))
+
+	w.sno++
+
+	_, err := w.writeValue([]byte(info))
+
+	return err
+}
+
+// newSegmentID generates a random segment ID. The most significant nibble of
+// the least significant bits of the ID is 0xa for data segments and 0xb for
+// bulk segments.
+func (w *Writer) newSegmentID(kind uint64) (uint64, uint64) {
+	var (
+		msb = w.rand.Uint64()&^0xf000 | 0x4000
+		lsb = w.rand.Uint64()&0x0fffffffffffffff | kind<<60
+	)
+	return msb, lsb
+}
+
+type encoder struct {
+	w    *Writer
+	self ID
+	data []byte
+}
+
+func (e *encoder) writeByte(v byte) {
+	e.data = append(e.data, v)
+}
+
+func (e *encoder) writeShort(v uint16) {
+	e.data = append(e.data, 0, 0)
+	binary.BigEndian.PutUint16(e.data[len(e.data)-2:], v)
+}
+
+func (e *encoder) writeInt(v uint32) {
+	e.data = append(e.data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.data[len(e.data)-4:], v)
+}
+
+func (e *encoder) writeLong(v uint64) {
+	e.data = append(e.data, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.data[len(e.data)-8:], v)
+}
+
+func (e *encoder) writeBytes(v []byte) {
+	e.data = append(e.data, v...)
+}
+
+func (e *encoder) writeID(id ID) {
+	reference := 0
+
+	if id.Msb != e.self.Msb || id.Lsb != e.self.Lsb {
+		reference = e.w.builder.AddReference(segment.Reference{Msb: id.Msb, Lsb: id.Lsb})
+		e.w.refs[[2]uint64{id.Msb, id.Lsb}] = true
+	}
+
+	e.writeShort(uint16(reference))
+	e.writeInt(uint32(id.Number))
+}
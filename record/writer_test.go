@@ -0,0 +1,232 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// memoryStore keeps the segments flushed by a Writer, so that they can be
+// read back by a Reader.
+type memoryStore map[[2]uint64][]byte
+
+func (m memoryStore) flush(s *Segment) error {
+	m[[2]uint64{s.Msb, s.Lsb}] = s.Data
+	return nil
+}
+
+func (m memoryStore) read(msb, lsb uint64) ([]byte, error) {
+	data, ok := m[[2]uint64{msb, lsb}]
+	if !ok {
+		return nil, fmt.Errorf("segment %s not found", uuid(msb, lsb))
+	}
+	return data, nil
+}
+
+// readTree reads the node record 'id' and its subtree. Properties and children
+// are sorted by name, so that the result can be compared with the original
+// tree regardless of the order they are stored in.
+func readTree(r *Reader, id ID) (*Node, error) {
+	nr, err := r.ReadNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{}
+
+	for _, p := range nr.Properties {
+		property := Property{Name: p.Name, Type: p.Type, Array: p.Array}
+
+		for _, vid := range p.Values {
+			v, err := r.ReadValue(vid, p.Type)
+			if err != nil {
+				return nil, err
+			}
+			property.Values = append(property.Values, v)
+		}
+
+		n.Properties = append(n.Properties, property)
+	}
+
+	for _, c := range nr.Children {
+		child, err := readTree(r, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, Child{Name: c.Name, Node: child})
+	}
+
+	sortTree(n)
+
+	return n, nil
+}
+
+func sortTree(n *Node) {
+	sort.Slice(n.Properties, func(i, j int) bool {
+		return n.Properties[i].Name < n.Properties[j].Name
+	})
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		sortTree(c.Node)
+	}
+}
+
+func testTree() *Node {
+	data := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	leaf := &Node{
+		Properties: []Property{
+			{Name: "jcr:primaryType", Type: TypeName, Values: []Value{{String: "nt:unstructured"}}},
+			{Name: "short", Type: TypeString, Values: []Value{{String: "hello"}}},
+			{Name: "medium", Type: TypeString, Values: []Value{{String: strings.Repeat("m", 1000)}}},
+			{Name: "long", Type: TypeString, Values: []Value{{String: strings.Repeat("l", 20000)}}},
+			{Name: "count", Type: TypeLong, Values: []Value{{String: "42"}}},
+			{Name: "tags", Type: TypeString, Array: true, Values: []Value{{String: "a"}, {String: "b"}}},
+			{Name: "blob", Type: TypeBinary, Values: []Value{{BlobID: "0123456789abcdef#1000"}}},
+			{Name: "inline", Type: TypeBinary, Values: []Value{{Binary: []byte("small binary")}}},
+			{Name: "big", Type: TypeBinary, Values: []Value{{Binary: data}}},
+		},
+	}
+
+	root := &Node{
+		Properties: []Property{
+			{Name: "jcr:primaryType", Type: TypeName, Values: []Value{{String: "rep:root"}}},
+			{Name: "jcr:mixinTypes", Type: TypeName, Array: true, Values: []Value{{String: "mix:a"}, {String: "mix:b"}}},
+		},
+		Children: []Child{
+			{Name: "only", Node: &Node{Children: []Child{{Name: "leaf", Node: leaf}}}},
+		},
+	}
+
+	many := &Node{}
+
+	for i := 0; i < 100; i++ {
+		many.Children = append(many.Children, Child{
+			Name: "child" + strconv.Itoa(i),
+			Node: &Node{Properties: []Property{{Name: "index", Type: TypeLong, Values: []Value{{String: strconv.Itoa(i)}}}}},
+		})
+	}
+
+	root.Children = append(root.Children, Child{Name: "many", Node: many})
+
+	sortTree(root)
+
+	return root
+}
+
+func TestWriteNodeReadNode(t *testing.T) {
+	var (
+		m    = make(memoryStore)
+		w    = NewWriter(rand.New(rand.NewSource(1)), m.flush)
+		tree = testTree()
+	)
+
+	id, err := w.WriteNode(tree)
+	if err != nil {
+		t.Fatalf("unable to write the tree: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	got, err := readTree(NewReader(m.read), id)
+	if err != nil {
+		t.Fatalf("unable to read the tree: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, tree) {
+		t.Fatalf("trees don't match")
+	}
+}
+
+func TestWriteValueTo(t *testing.T) {
+	var (
+		m    = make(memoryStore)
+		w    = NewWriter(rand.New(rand.NewSource(1)), m.flush)
+		data = bytes.Repeat([]byte("0123456789"), 50000)
+	)
+
+	id, err := w.writeBinary(Value{Binary: data})
+	if err != nil {
+		t.Fatalf("unable to write the value: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	var b bytes.Buffer
+
+	n, err := NewReader(m.read).WriteValueTo(id, &b)
+	if err != nil {
+		t.Fatalf("unable to read the value: %v", err)
+	}
+
+	if n != int64(len(data)) || !bytes.Equal(b.Bytes(), data) {
+		t.Fatalf("values don't match")
+	}
+}
+
+func TestValueLength(t *testing.T) {
+	var (
+		m = make(memoryStore)
+		w = NewWriter(rand.New(rand.NewSource(1)), m.flush)
+	)
+
+	lengths := []int{0, 5, 1000, 20000, 500000}
+
+	var ids []ID
+
+	for _, n := range lengths {
+		id, err := w.writeBinary(Value{Binary: bytes.Repeat([]byte("x"), n)})
+		if err != nil {
+			t.Fatalf("unable to write the value: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush the writer: %v", err)
+	}
+
+	// The blocks of long values are stored in bulk segments, which must not
+	// be read.
+
+	r := NewReader(func(msb, lsb uint64) ([]byte, error) {
+		if lsb>>60 == 0xb {
+			return nil, fmt.Errorf("bulk segment %s read", uuid(msb, lsb))
+		}
+		return m.read(msb, lsb)
+	})
+
+	for i, id := range ids {
+		n, err := r.ValueLength(id)
+		if err != nil {
+			t.Fatalf("unable to read the length of the value: %v", err)
+		}
+		if n != lengths[i] {
+			t.Fatalf("unexpected length: got %d, want %d", n, lengths[i])
+		}
+	}
+}
+
+func TestWriteRecordSizeMismatch(t *testing.T) {
+	w := NewWriter(rand.New(rand.NewSource(1)), make(memoryStore).flush)
+
+	_, err := w.writeRecord(0, 4, nil, func(e *encoder) {
+		e.writeByte(1)
+	})
+
+	if err == nil {
+		t.Fatalf("size mismatch not detected")
+	}
+}
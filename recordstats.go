@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"./segment"
+)
+
+// recordStatsTypes are the record types in the order they are printed.
+var recordStatsTypes = []segment.RecordType{
+	segment.RecordTypeNode,
+	segment.RecordTypeTemplate,
+	segment.RecordTypeValue,
+	segment.RecordTypeMapLeaf,
+	segment.RecordTypeMapBranch,
+	segment.RecordTypeList,
+	segment.RecordTypeListBucket,
+	segment.RecordTypeBlock,
+	segment.RecordTypeBlobID,
+}
+
+// recordStats accumulates the number and the estimated size of the records of
+// every type.
+type recordStats map[segment.RecordType]*statsCount
+
+// printRecordStats prints the number and the estimated size of the records of
+// every type. If 'p' is a directory, the records of every active TAR file are
+// considered. Otherwise, 'p' is a TAR file and either the records of the
+// segment 'id' or, if 'id' is empty, the records of every segment are
+// considered.
+func printRecordStats(p, id string, w io.Writer) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	stats := make(recordStats)
+
+	switch {
+	case info.IsDir() && id != "":
+		return fmt.Errorf("a segment ID can only be specified with a TAR file")
+	case info.IsDir():
+		err = forEachTarFile(p, false, func(name string) error {
+			return forEachMatchingEntry(filepath.Join(p, name), isAnySegment, stats.add)
+		})
+	case id != "":
+		err = onMatchingEntry(p, isSegment(id), stats.add)
+	default:
+		err = forEachMatchingEntry(p, isAnySegment, stats.add)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range recordStatsTypes {
+		c := stats[t]
+		if c == nil {
+			c = &statsCount{}
+		}
+		fmt.Fprintf(w, "%s %d %d\n", recordType(t), c.count, c.size)
+	}
+
+	return nil
+}
+
+// add adds the records of a segment entry to the statistics. Bulk segments
+// don't have a table of records, and their content is counted as blocks of the
+// maximum size.
+func (stats recordStats) add(n string, r io.Reader) error {
+	const blockSize = 4096
+
+	var s segment.Segment
+
+	if id := normalizeSegmentID(entryNameToSegmentID(n)); isBulkSegmentID(id) {
+		size, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return err
+		}
+		c := stats.count(segment.RecordTypeBlock)
+		c.count += int((size + blockSize - 1) / blockSize)
+		c.size += size
+		return nil
+	}
+
+	if _, err := s.ReadFrom(r); err != nil {
+		return fmt.Errorf("unable to read segment %s: %v", n, err)
+	}
+
+	sizes := s.RecordSizes()
+
+	for _, record := range s.Records {
+		stats.count(record.Type).add(int64(sizes[record.Number]))
+	}
+
+	return nil
+}
+
+func (stats recordStats) count(t segment.RecordType) *statsCount {
+	if stats[t] == nil {
+		stats[t] = &statsCount{}
+	}
+	return stats[t]
+}
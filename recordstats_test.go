@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"./segment"
+)
+
+func TestRecordStats(t *testing.T) {
+	directory := t.TempDir()
+
+	// Records are aligned to four bytes, and the estimated size of a record
+	// includes its padding.
+
+	data := testDataSegment(t, 1,
+		testRecord{segment.RecordTypeNode, 10},
+		testRecord{segment.RecordTypeNode, 12},
+		testRecord{segment.RecordTypeTemplate, 5},
+		testRecord{segment.RecordTypeValue, 1},
+		testRecord{segment.RecordTypeValue, 4096},
+		testRecord{segment.RecordTypeMapLeaf, 7},
+		testRecord{segment.RecordTypeMapBranch, 8},
+		testRecord{segment.RecordTypeList, 3},
+		testRecord{segment.RecordTypeListBucket, 6},
+		testRecord{segment.RecordTypeBlobID, 30},
+	)
+
+	tar := filepath.Join(directory, "data00000a.tar")
+
+	writeTestTar(t, tar, []testTarSegment{
+		{msb: 1, lsb: 0xa000000000000001, generation: 1, fullGeneration: 1, data: data},
+		{msb: 2, lsb: 0xb000000000000002, generation: 1, fullGeneration: 1, data: make([]byte, 5000)},
+	})
+
+	tests := []struct {
+		p, id string
+		want  []string
+	}{
+		{
+			p: directory,
+			want: []string{
+				"node 2 24",
+				"template 1 8",
+				"value 2 4100",
+				"leaf 1 8",
+				"branch 1 8",
+				"list 1 4",
+				"bucket 1 8",
+				"block 2 5000",
+				"binary 1 32",
+			},
+		},
+		{
+			p:  tar,
+			id: segmentID(2, 0xb000000000000002),
+			want: []string{
+				"node 0 0",
+				"template 0 0",
+				"value 0 0",
+				"leaf 0 0",
+				"branch 0 0",
+				"list 0 0",
+				"bucket 0 0",
+				"block 2 5000",
+				"binary 0 0",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+
+		if err := printRecordStats(test.p, test.id, &out); err != nil {
+			t.Fatalf("unable to print the statistics: %v", err)
+		}
+
+		if got, want := strings.TrimSpace(out.String()), strings.Join(test.want, "\n"); got != want {
+			t.Fatalf("unexpected statistics of %s %s:\n%s\nwant:\n%s", test.p, test.id, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"./record"
+	"./store"
+)
+
+// printReferrers prints the segments referencing the segment 'id', and the
+// paths of the nodes whose records are stored in those segments. The records of
+// a node include the templates, lists, maps and values read to read it, so a
+// node is reported even if only its properties or the map of its children are
+// in a referrer. Paths are relative to the super root of the head revision, so
+// that nodes reachable only from checkpoints are found too.
+func printReferrers(directory, id string, w io.Writer) error {
+	sid, err := store.ParseSegmentID(id)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		return err
+	}
+
+	target := g.Segment(sid)
+
+	if target == nil {
+		return fmt.Errorf("segment %s is not in the store and is not referenced", sid)
+	}
+
+	referrers := make(map[store.SegmentID]bool)
+
+	for _, r := range target.Referrers {
+		fmt.Fprintf(w, "referrer %s %s %s\n", segmentType(r.ID.String()), r.ID, r.Tar)
+		referrers[r.ID] = true
+	}
+
+	if len(referrers) == 0 {
+		return nil
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	r := newRecordReader(s)
+
+	return walkTree(r, head, "/", func(path string, id record.ID, n *record.NodeRecord, err error) error {
+		if err != nil {
+			fmt.Fprintf(w, "unreadable %s %s\n", id, path)
+			return nil
+		}
+
+		ids, _, err := nodeRecords(r, id, n)
+		if err != nil {
+			fmt.Fprintf(w, "unreadable %s %s\n", id, path)
+			return nil
+		}
+
+		printed := make(map[store.SegmentID]bool)
+
+		for _, id := range ids {
+			if sid := recordSegmentID(id); referrers[sid] && !printed[sid] {
+				fmt.Fprintf(w, "path %s %s\n", sid, path)
+				printed[sid] = true
+			}
+		}
+
+		return nil
+	})
+}
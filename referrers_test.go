@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"./store"
+)
+
+func TestReferrersPaths(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        3,
+		tars:        1,
+		generations: 1,
+		depth:       1,
+		fanout:      2,
+		properties:  2,
+		binaries:    2,
+		journal:     3,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		t.Fatalf("unable to read the graph: %v", err)
+	}
+
+	// Every segment of a store with a single generation is reachable from
+	// the head, so every referrer holds the records of at least one node.
+
+	for _, gs := range g.Segments {
+		if len(gs.Referrers) == 0 {
+			continue
+		}
+
+		var b bytes.Buffer
+
+		if err := printReferrers(directory, gs.ID.String(), &b); err != nil {
+			t.Fatalf("unable to print the referrers of %s: %v", gs.ID, err)
+		}
+
+		for _, r := range gs.Referrers {
+			if !strings.Contains(b.String(), "path "+r.ID.String()+" ") {
+				t.Fatalf("no path for referrer %s of %s:\n%s", r.ID, gs.ID, b.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxSize is the maximum size of a segment. The offsets of the records are
+// relative to a segment of this size, whose content is aligned to its end.
+const MaxSize = 256 * 1024
+
+const (
+	headerSize    = 32
+	referenceSize = 16
+	recordSize    = 9
+	recordAlign   = 4
+)
+
+// A Builder lays out the binary representation of a data segment. The header
+// fields are written as they are, while records are added one by one and are
+// stored from the end of the segment towards its beginning.
+type Builder struct {
+	Version        int
+	Generation     int
+	FullGeneration int
+	Compacted      bool
+	References     []Reference
+
+	records []Record
+	data    [][]byte
+	length  int
+}
+
+// AddReference adds a reference towards another segment, if it is not already
+// present, and returns its number. Reference numbers start from one, since the
+// number zero always refers to the segment itself.
+func (builder *Builder) AddReference(reference Reference) int {
+	for i, r := range builder.References {
+		if r == reference {
+			return i + 1
+		}
+	}
+	builder.References = append(builder.References, reference)
+	return len(builder.References)
+}
+
+// AddRecord adds a record of type 't' to the segment and returns its record
+// number. An error is returned if the record is empty, since its offset would
+// point to the end of the segment, or if it doesn't fit in the segment.
+func (builder *Builder) AddRecord(t RecordType, data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("empty record")
+	}
+
+	size := align(len(data), recordAlign)
+
+	if headerSizeFor(len(builder.References), len(builder.records)+1)+builder.length+size > MaxSize {
+		return 0, fmt.Errorf("record too big for the segment")
+	}
+
+	// Every record is stored before the ones added earlier, so the content
+	// is kept as a list of aligned records and reversed when it's written.
+
+	record := make([]byte, size)
+	copy(record, data)
+
+	builder.data = append(builder.data, record)
+	builder.length += size
+
+	offset := MaxSize - builder.length
+
+	builder.records = append(builder.records, Record{
+		Number: len(builder.records),
+		Type:   t,
+		Offset: offset,
+	})
+
+	return len(builder.records) - 1, nil
+}
+
+// Fits reports whether a record of 'size' bytes fits in the segment, assuming
+// that 'references' new references are added to the segment together with it.
+func (builder *Builder) Fits(size, references int) bool {
+	return headerSizeFor(len(builder.References)+references, len(builder.records)+1)+builder.length+align(size, recordAlign) <= MaxSize
+}
+
+// Size returns the size of the segment built so far.
+func (builder *Builder) Size() int {
+	return headerSizeFor(len(builder.References), len(builder.records)) + builder.length
+}
+
+// WriteTo writes the segment to 'w'. Returns the number of bytes written and an
+// optional error.
+func (builder *Builder) WriteTo(w io.Writer) (int64, error) {
+	const (
+		headerMagic                = "0aK"
+		headerVersionOffset        = 3
+		headerFullGenerationOffset = 4
+		headerGenerationOffset     = 10
+		headerReferenceCountOffset = 14
+		headerRecordCountOffset    = 18
+	)
+
+	if builder.Version != v12 && builder.Version != v13 {
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, builder.Version)
+	}
+
+	if builder.Size() > MaxSize {
+		return 0, fmt.Errorf("segment too big")
+	}
+
+	header := make([]byte, headerSizeFor(len(builder.References), len(builder.records)))
+
+	copy(header, headerMagic)
+	header[headerVersionOffset] = byte(builder.Version)
+
+	if builder.Version == v13 {
+		fullGeneration := uint32(builder.FullGeneration) & 0x7fffffff
+		if builder.Compacted {
+			fullGeneration |= 0x80000000
+		}
+		binary.BigEndian.PutUint32(header[headerFullGenerationOffset:], fullGeneration)
+	}
+
+	binary.BigEndian.PutUint32(header[headerGenerationOffset:], uint32(builder.Generation))
+	binary.BigEndian.PutUint32(header[headerReferenceCountOffset:], uint32(len(builder.References)))
+	binary.BigEndian.PutUint32(header[headerRecordCountOffset:], uint32(len(builder.records)))
+
+	for i, r := range builder.References {
+		referenceData := header[headerSize+i*referenceSize:]
+		binary.BigEndian.PutUint64(referenceData[0:], r.Msb)
+		binary.BigEndian.PutUint64(referenceData[8:], r.Lsb)
+	}
+
+	for i, r := range builder.records {
+		recordData := header[headerSize+len(builder.References)*referenceSize+i*recordSize:]
+		binary.BigEndian.PutUint32(recordData[0:], uint32(r.Number))
+		recordData[4] = byte(r.Type)
+		binary.BigEndian.PutUint32(recordData[5:], uint32(r.Offset))
+	}
+
+	var b bytes.Buffer
+
+	b.Write(header)
+
+	for i := len(builder.data) - 1; i >= 0; i-- {
+		b.Write(builder.data[i])
+	}
+
+	return b.WriteTo(w)
+}
+
+// A BulkBuilder lays out the binary representation of a bulk segment. A bulk
+// segment has no header and only contains block records, stored one after the
+// other and aligned to the end of the segment.
+type BulkBuilder struct {
+	blocks [][]byte
+	length int
+}
+
+// AddBlock adds a block record to the segment. An error is returned if the
+// block is empty, since it would share its number with the next block, or if
+// it doesn't fit in the segment.
+func (builder *BulkBuilder) AddBlock(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty block")
+	}
+	if align(builder.length+len(data), recordAlign) > MaxSize {
+		return fmt.Errorf("block too big for the segment")
+	}
+	builder.blocks = append(builder.blocks, data)
+	builder.length += len(data)
+	return nil
+}
+
+// Size returns the size of the segment built so far.
+func (builder *BulkBuilder) Size() int {
+	return align(builder.length, recordAlign)
+}
+
+// Records returns the block records of the segment, in the order they were
+// added. The number of a record in a bulk segment is equal to its offset. Since
+// the content of the segment is aligned to its end, adding a block changes the
+// numbers of the blocks added before it.
+func (builder *BulkBuilder) Records() []Record {
+	var (
+		records []Record
+		offset  = MaxSize - builder.Size()
+	)
+
+	for _, b := range builder.blocks {
+		records = append(records, Record{
+			Number: offset,
+			Type:   RecordTypeBlock,
+			Offset: offset,
+		})
+		offset += len(b)
+	}
+
+	return records
+}
+
+// WriteTo writes the segment to 'w'. Returns the number of bytes written and an
+// optional error.
+func (builder *BulkBuilder) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+
+	for _, block := range builder.blocks {
+		b.Write(block)
+	}
+
+	b.Write(make([]byte, builder.Size()-builder.length))
+
+	return b.WriteTo(w)
+}
+
+func headerSizeFor(nreferences, nrecords int) int {
+	return align(headerSize+nreferences*referenceSize+nrecords*recordSize, 16)
+}
+
+func align(n, boundary int) int {
+	return (n + boundary - 1) / boundary * boundary
+}
@@ -0,0 +1,182 @@
+package segment
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func buildSegment(t *testing.T, builder *Builder) ([]byte, *Segment) {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	if _, err := builder.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	if b.Len() != builder.Size() {
+		t.Fatalf("unexpected segment size: got %d, want %d", b.Len(), builder.Size())
+	}
+
+	data := b.Bytes()
+
+	var s Segment
+
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unable to read the segment: %v", err)
+	}
+
+	return data, &s
+}
+
+func recordData(data []byte, r Record, size int) []byte {
+	start := len(data) - (MaxSize - r.Offset)
+	return data[start : start+size]
+}
+
+func TestBuilderRoundTrip(t *testing.T) {
+	tests := []struct {
+		version        int
+		fullGeneration int
+		compacted      bool
+	}{
+		{version: v12, fullGeneration: 3, compacted: true},
+		{version: v13, fullGeneration: 2, compacted: false},
+		{version: v13, fullGeneration: 2, compacted: true},
+	}
+
+	records := []struct {
+		t    RecordType
+		data []byte
+	}{
+		{RecordTypeValue, []byte("a")},
+		{RecordTypeNode, []byte("hello, world")},
+		{RecordTypeMapLeaf, bytes.Repeat([]byte{0xff}, 1000)},
+	}
+
+	for _, test := range tests {
+		builder := &Builder{
+			Version:        test.version,
+			Generation:     3,
+			FullGeneration: test.fullGeneration,
+			Compacted:      test.compacted,
+		}
+
+		builder.AddReference(Reference{Msb: 1, Lsb: 2})
+		builder.AddReference(Reference{Msb: 3, Lsb: 4})
+
+		if n := builder.AddReference(Reference{Msb: 1, Lsb: 2}); n != 1 {
+			t.Fatalf("unexpected reference number %d", n)
+		}
+
+		for i, r := range records {
+			n, err := builder.AddRecord(r.t, r.data)
+			if err != nil {
+				t.Fatalf("unable to add the record: %v", err)
+			}
+			if n != i {
+				t.Fatalf("unexpected record number: got %d, want %d", n, i)
+			}
+		}
+
+		data, s := buildSegment(t, builder)
+
+		want := &Segment{
+			Version:        test.version,
+			Generation:     3,
+			FullGeneration: test.fullGeneration,
+			Compacted:      test.compacted,
+			References:     builder.References,
+			Records:        builder.records,
+		}
+
+		if !reflect.DeepEqual(s, want) {
+			t.Fatalf("segments don't match: got %+v, want %+v", s, want)
+		}
+
+		for i, r := range s.Records {
+			if got := recordData(data, r, len(records[i].data)); !bytes.Equal(got, records[i].data) {
+				t.Fatalf("content of record %d doesn't match: got %x, want %x", i, got, records[i].data)
+			}
+		}
+	}
+}
+
+func TestBuilderEmpty(t *testing.T) {
+	_, s := buildSegment(t, &Builder{Version: v13})
+
+	if len(s.References) != 0 || len(s.Records) != 0 {
+		t.Fatalf("unexpected content %+v", s)
+	}
+}
+
+func TestBuilderRejectsEmptyRecord(t *testing.T) {
+	var builder Builder
+
+	if _, err := builder.AddRecord(RecordTypeValue, nil); err == nil {
+		t.Fatalf("empty record accepted")
+	}
+}
+
+func TestBuilderRejectsBigRecord(t *testing.T) {
+	var builder Builder
+
+	if _, err := builder.AddRecord(RecordTypeValue, make([]byte, MaxSize)); err == nil {
+		t.Fatalf("record bigger than the segment accepted")
+	}
+
+	if builder.Size() != headerSizeFor(0, 0) {
+		t.Fatalf("rejected record changed the segment size to %d", builder.Size())
+	}
+}
+
+func TestBulkBuilderRoundTrip(t *testing.T) {
+	blocks := [][]byte{
+		bytes.Repeat([]byte{1}, 4096),
+		bytes.Repeat([]byte{2}, 4096),
+		[]byte("tail"),
+		[]byte("odd"),
+	}
+
+	var builder BulkBuilder
+
+	for _, b := range blocks {
+		if err := builder.AddBlock(b); err != nil {
+			t.Fatalf("unable to add the block: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+
+	if _, err := builder.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	if b.Len() != builder.Size() || b.Len()%recordAlign != 0 {
+		t.Fatalf("unexpected segment size %d", b.Len())
+	}
+
+	records := builder.Records()
+
+	if len(records) != len(blocks) {
+		t.Fatalf("unexpected number of records %d", len(records))
+	}
+
+	for i, r := range records {
+		if r.Number != r.Offset || r.Type != RecordTypeBlock {
+			t.Fatalf("unexpected record %+v", r)
+		}
+		if got := recordData(b.Bytes(), r, len(blocks[i])); !bytes.Equal(got, blocks[i]) {
+			t.Fatalf("content of block %d doesn't match", i)
+		}
+	}
+}
+
+func TestBulkBuilderRejectsEmptyBlock(t *testing.T) {
+	var builder BulkBuilder
+
+	if err := builder.AddBlock(nil); err == nil {
+		t.Fatalf("empty block accepted")
+	}
+}
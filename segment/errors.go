@@ -0,0 +1,45 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMagic is returned when the segment doesn't start with the expected
+	// magic bytes.
+	ErrMagic = errors.New("invalid magic")
+	// ErrTruncated is returned when the segment is shorter than its header
+	// declares.
+	ErrTruncated = errors.New("truncated data")
+	// ErrCorrupt is returned when the offset of a record points outside of
+	// the data of the segment.
+	ErrCorrupt = errors.New("corrupt record")
+	// ErrUnsupportedVersion is returned when the version of the segment is not
+	// supported.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+// ParseError describes why a segment couldn't be parsed. Err is one of the
+// errors defined by this package and can be tested with errors.Is. Offset is
+// the position in the segment of the field that failed validation. The meaning
+// of Expected and Actual depends on Err: for ErrMagic they are the magic
+// bytes, for ErrTruncated they are the required and available number of bytes,
+// for ErrCorrupt they are the lowest valid offset and the offset of the record,
+// and for ErrUnsupportedVersion they are the supported version and the version
+// found in the segment.
+type ParseError struct {
+	Err      error
+	Offset   int
+	Expected int64
+	Actual   int64
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d: expected %d, actual %d", e.Err, e.Offset, e.Expected, e.Actual)
+}
+
+// Unwrap returns Err.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
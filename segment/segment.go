@@ -3,8 +3,8 @@ package segment
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
+	"sort"
 )
 
 // A Segment is a container for records.
@@ -68,6 +68,30 @@ func (segment *Segment) ReadFrom(reader io.Reader) (int64, error) {
 	return n, segment.parseFrom(buffer.Bytes())
 }
 
+// RecordSizes returns the estimated size of every record, indexed by record
+// number. Records are stored one after the other, so the size of a record is
+// estimated as the gap between its offset and the offset of the next record.
+// The estimate includes the padding used to align records.
+func (segment *Segment) RecordSizes() map[int]int {
+	records := append([]Record(nil), segment.Records...)
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Offset < records[j].Offset
+	})
+
+	sizes := make(map[int]int, len(records))
+
+	for i, r := range records {
+		end := MaxSize
+		if i+1 < len(records) {
+			end = records[i+1].Offset
+		}
+		sizes[r.Number] = end - r.Offset
+	}
+
+	return sizes
+}
+
 const (
 	v12 = 12
 	v13 = 13
@@ -75,7 +99,7 @@ const (
 
 func (segment *Segment) parseFrom(data []byte) error {
 	if len(data) < 4 {
-		return fmt.Errorf("invalid data")
+		return &ParseError{Err: ErrTruncated, Expected: 4, Actual: int64(len(data))}
 	}
 
 	version := data[3]
@@ -87,7 +111,7 @@ func (segment *Segment) parseFrom(data []byte) error {
 		return segment.parsev13From(data)
 	}
 
-	return fmt.Errorf("invalid version %02x", version)
+	return &ParseError{Err: ErrUnsupportedVersion, Offset: 3, Expected: v13, Actual: int64(version)}
 }
 
 func (segment *Segment) parsev12From(data []byte) error {
@@ -120,7 +144,7 @@ func (segment *Segment) parsev12From(data []byte) error {
 	)
 
 	if len(data) < headerSize {
-		return fmt.Errorf("Segment too small")
+		return &ParseError{Err: ErrTruncated, Expected: headerSize, Actual: int64(len(data))}
 	}
 
 	var (
@@ -132,15 +156,15 @@ func (segment *Segment) parsev12From(data []byte) error {
 	)
 
 	if magic != headerMagic {
-		return fmt.Errorf("Invalid magic")
+		return &ParseError{Err: ErrMagic, Offset: headerMagicOffset, Expected: magicValue(headerMagic), Actual: magicValue(magic)}
 	}
 
-	if len(data) < headerSize+nreferences*referenceSize+nrecords*recordSize {
-		return fmt.Errorf("Invalid size or segment header")
+	if size := headerSize + nreferences*referenceSize + nrecords*recordSize; len(data) < size {
+		return &ParseError{Err: ErrTruncated, Offset: headerReferenceCountOffset, Expected: int64(size), Actual: int64(len(data))}
 	}
 
 	if version != headerVersion {
-		return fmt.Errorf("invalid version %02x", version)
+		return &ParseError{Err: ErrUnsupportedVersion, Offset: headerVersionOffset, Expected: headerVersion, Actual: int64(version)}
 	}
 
 	segment.Generation = generation
@@ -163,7 +187,7 @@ func (segment *Segment) parsev12From(data []byte) error {
 		segment.Records[i].Offset = int(binary.BigEndian.Uint32(recordData[recordOffsetOffset:]))
 	}
 
-	return nil
+	return checkRecordOffsets(data, headerSize+nreferences*referenceSize, segment.Records)
 }
 
 func (segment *Segment) parsev13From(data []byte) error {
@@ -197,7 +221,7 @@ func (segment *Segment) parsev13From(data []byte) error {
 	)
 
 	if len(data) < headerSize {
-		return fmt.Errorf("Segment too small")
+		return &ParseError{Err: ErrTruncated, Expected: headerSize, Actual: int64(len(data))}
 	}
 
 	var (
@@ -211,15 +235,15 @@ func (segment *Segment) parsev13From(data []byte) error {
 	)
 
 	if magic != headerMagic {
-		return fmt.Errorf("Invalid magic")
+		return &ParseError{Err: ErrMagic, Offset: headerMagicOffset, Expected: magicValue(headerMagic), Actual: magicValue(magic)}
 	}
 
-	if len(data) < headerSize+nreferences*referenceSize+nrecords*recordSize {
-		return fmt.Errorf("Invalid size or segment header")
+	if size := headerSize + nreferences*referenceSize + nrecords*recordSize; len(data) < size {
+		return &ParseError{Err: ErrTruncated, Offset: headerReferenceCountOffset, Expected: int64(size), Actual: int64(len(data))}
 	}
 
 	if version != headerVersion {
-		return fmt.Errorf("invalid version %02x", version)
+		return &ParseError{Err: ErrUnsupportedVersion, Offset: headerVersionOffset, Expected: headerVersion, Actual: int64(version)}
 	}
 
 	segment.Generation = generation
@@ -242,5 +266,33 @@ func (segment *Segment) parsev13From(data []byte) error {
 		segment.Records[i].Offset = int(binary.BigEndian.Uint32(recordData[recordOffsetOffset:]))
 	}
 
+	return checkRecordOffsets(data, headerSize+nreferences*referenceSize, segment.Records)
+}
+
+// checkRecordOffsets verifies that the offset of every record points to the
+// data of the segment, which starts after the header and ends at MaxSize.
+// 'tableStart' is the position of the first entry of the record table.
+func checkRecordOffsets(data []byte, tableStart int, records []Record) error {
+	const (
+		recordSize         = 9
+		recordOffsetOffset = 5
+	)
+
+	start := MaxSize - len(data) + tableStart + len(records)*recordSize
+
+	for i, r := range records {
+		if r.Offset < start || r.Offset >= MaxSize {
+			return &ParseError{Err: ErrCorrupt, Offset: tableStart + i*recordSize + recordOffsetOffset, Expected: int64(start), Actual: int64(r.Offset)}
+		}
+	}
+
 	return nil
 }
+
+func magicValue(magic string) int64 {
+	var v int64
+	for i := 0; i < len(magic); i++ {
+		v = v<<8 | int64(magic[i])
+	}
+	return v
+}
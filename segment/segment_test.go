@@ -0,0 +1,126 @@
+package segment
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, version := range []int{v12, v13} {
+		builder := &Builder{Version: version, Generation: 1, FullGeneration: 1}
+		builder.AddReference(Reference{Msb: 1, Lsb: 2})
+		builder.AddRecord(RecordTypeValue, []byte("value"))
+		builder.AddRecord(RecordTypeNode, []byte("node"))
+
+		var b bytes.Buffer
+		builder.WriteTo(&b)
+		f.Add(b.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Segment
+
+		if err := s.parseFrom(data); err != nil {
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			return
+		}
+
+		start := MaxSize - len(data)
+
+		for _, r := range s.Records {
+			if r.Offset < start || r.Offset >= MaxSize {
+				t.Fatalf("record %d outside of the segment: offset %d", r.Number, r.Offset)
+			}
+		}
+
+		for number, size := range s.RecordSizes() {
+			if size < 0 || size > MaxSize {
+				t.Fatalf("invalid size %d of record %d", size, number)
+			}
+		}
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	builder := &Builder{Version: v13}
+	builder.AddRecord(RecordTypeValue, []byte("value"))
+
+	var b bytes.Buffer
+	builder.WriteTo(&b)
+
+	valid := b.Bytes()
+
+	corrupt := func(f func(data []byte)) []byte {
+		data := append([]byte(nil), valid...)
+		f(data)
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		offset int
+	}{
+		{"empty", nil, ErrTruncated, 0},
+		{"header", valid[:20], ErrTruncated, 0},
+		{"records", valid[:headerSize+4], ErrTruncated, 14},
+		{"magic", corrupt(func(data []byte) { data[0] = 'x' }), ErrMagic, 0},
+		{"version", corrupt(func(data []byte) { data[3] = 11 }), ErrUnsupportedVersion, 3},
+		{"offset", corrupt(func(data []byte) { data[headerSize+5] = 0xff }), ErrCorrupt, headerSize + 5},
+	}
+
+	for _, test := range tests {
+		var s Segment
+
+		_, err := s.ReadFrom(bytes.NewReader(test.data))
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: unexpected error %v, want %v", test.name, err, test.err)
+		}
+
+		var pe *ParseError
+
+		if !errors.As(err, &pe) {
+			t.Fatalf("%s: unexpected error type %T", test.name, err)
+		}
+
+		if pe.Offset != test.offset {
+			t.Fatalf("%s: unexpected offset %d, want %d", test.name, pe.Offset, test.offset)
+		}
+	}
+}
+
+func TestRecordSizes(t *testing.T) {
+	lengths := []int{1, 4, 5, 1000, 3}
+
+	for _, version := range []int{v12, v13} {
+		builder := &Builder{Version: version}
+
+		for _, n := range lengths {
+			if _, err := builder.AddRecord(RecordTypeValue, bytes.Repeat([]byte{1}, n)); err != nil {
+				t.Fatalf("unable to add the record: %v", err)
+			}
+		}
+
+		_, s := buildSegment(t, builder)
+
+		sizes := s.RecordSizes()
+
+		if len(sizes) != len(lengths) {
+			t.Fatalf("v%d: unexpected number of sizes %d", version, len(sizes))
+		}
+
+		// The size of a record includes the padding aligning the next one.
+
+		for i, n := range lengths {
+			if want := align(n, recordAlign); sizes[s.Records[i].Number] != want {
+				t.Fatalf("v%d: unexpected size of record %d: got %d, want %d", version, i, sizes[s.Records[i].Number], want)
+			}
+		}
+	}
+}
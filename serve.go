@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"./binaries"
+	"./graph"
+	"./record"
+	"./segment"
+	"./store"
+)
+
+// server serves a read-only JSON API for a store. The store is opened once,
+// and the segments and the graph read to serve a request are kept in memory
+// and shared with the following requests. Requests are served concurrently.
+type server struct {
+	store  *store.Store
+	reader *record.Reader
+
+	// The graph is read by the first request needing it.
+	graphOnce sync.Once
+	graph     *store.Graph
+	graphErr  error
+}
+
+// httpError is an error with the status code to send in the response.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+func badRequest(err error) error {
+	return &httpError{http.StatusBadRequest, err}
+}
+
+// serveStore opens the store in 'directory' and serves its API on 'addr'.
+func serveStore(directory, addr string) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+	return http.ListenAndServe(addr, newServer(s).handler())
+}
+
+func newServer(s *store.Store) *server {
+	return &server{store: s, reader: newRecordReader(s)}
+}
+
+// handler returns the handler of the API. Every endpoint is under /api, and
+// the parameters of an endpoint are the parts of the path following its name.
+func (sv *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/tars", sv.json("/api/tars", sv.tars))
+	mux.Handle("/api/tars/", sv.json("/api/tars/", sv.tar))
+	mux.Handle("/api/graph", sv.json("/api/graph", sv.storeGraph))
+	mux.Handle("/api/graph/", sv.json("/api/graph/", sv.neighbourhood))
+	mux.Handle("/api/segments/", sv.json("/api/segments/", sv.segment))
+	mux.Handle("/api/records/", sv.json("/api/records/", sv.record))
+	mux.Handle("/api/journal", sv.json("/api/journal", sv.journal))
+	mux.Handle("/api/nodes/", sv.json("/api/nodes/", sv.node))
+	return mux
+}
+
+// json adapts a function returning an object to an HTTP handler printing the
+// object as JSON. The function receives the part of the path following
+// 'prefix'. Errors are printed as an object with an "error" field.
+func (sv *server) json(prefix string, f func(p string, r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var (
+			o   interface{}
+			err error
+		)
+
+		if r.Method == http.MethodGet {
+			o, err = f(strings.TrimPrefix(r.URL.Path, prefix), r)
+		} else {
+			err = &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+		}
+
+		if err != nil {
+			status := http.StatusInternalServerError
+
+			var he *httpError
+
+			if errors.As(err, &he) {
+				status = he.status
+			}
+
+			w.WriteHeader(status)
+			o = map[string]string{"error": err.Error()}
+		}
+
+		json.NewEncoder(w).Encode(o)
+	})
+}
+
+func (sv *server) tars(_ string, _ *http.Request) (interface{}, error) {
+	tars := []jsonStoreTar{}
+
+	for _, t := range sv.store.Tars {
+		o := jsonStoreTar{Name: t.Name, Segments: len(t.Index.Entries)}
+		for _, e := range t.Index.Entries {
+			o.Size += int64(e.Size)
+		}
+		tars = append(tars, o)
+	}
+
+	return tars, nil
+}
+
+// tar returns the index, the graph or the binary references index of a TAR
+// file. The path is the name of the TAR file followed by the name of the
+// entry.
+func (sv *server) tar(p string, _ *http.Request) (interface{}, error) {
+	i := strings.Index(p, "/")
+	if i < 0 {
+		return nil, notFound("endpoint not found")
+	}
+
+	name, entry := p[:i], p[i+1:]
+
+	var t *store.Tar
+
+	for _, c := range sv.store.Tars {
+		if c.Name == name {
+			t = c
+		}
+	}
+
+	if t == nil {
+		return nil, notFound("TAR file %s not found", name)
+	}
+
+	switch entry {
+	case "index":
+		return sv.tarIndex(t)
+	case "graph":
+		return sv.tarGraph(t)
+	case "binaries":
+		return sv.tarBinaries(t)
+	default:
+		return nil, notFound("endpoint not found")
+	}
+}
+
+func (sv *server) tarIndex(t *store.Tar) (interface{}, error) {
+	entries := []jsonIndexEntry{}
+
+	for _, e := range t.Index.Entries {
+		entries = append(entries, newJSONIndexEntry(e))
+	}
+
+	return entries, nil
+}
+
+func (sv *server) tarGraph(t *store.Tar) (interface{}, error) {
+	var gph graph.Graph
+
+	ok, err := t.ReadGraph(&gph)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, notFound("TAR file %s has no graph", t.Name)
+	}
+
+	entries := []jsonGraphEntry{}
+
+	for _, e := range gph.Entries {
+		entries = append(entries, newJSONGraphEntry(e))
+	}
+
+	return entries, nil
+}
+
+func (sv *server) tarBinaries(t *store.Tar) (interface{}, error) {
+	var bns binaries.Binaries
+
+	ok, err := t.ReadBinaries(&bns)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, notFound("TAR file %s has no binary references index", t.Name)
+	}
+
+	return append([]jsonBinaries{}, newJSONBinaries(&bns)...), nil
+}
+
+func (sv *server) storeGraph(_ string, _ *http.Request) (interface{}, error) {
+	g, err := sv.readGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []jsonGraphSegment{}
+
+	for _, s := range g.Segments {
+		segments = append(segments, newJSONGraphSegment(s))
+	}
+
+	return segments, nil
+}
+
+// neighbourhood returns a segment in the graph of the store, together with the
+// segments it references and the segments referencing it.
+func (sv *server) neighbourhood(p string, _ *http.Request) (interface{}, error) {
+	id, err := store.ParseSegmentID(p)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	g, err := sv.readGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	s := g.Segment(id)
+
+	if s == nil {
+		return nil, notFound("segment %s is not in the store and is not referenced", id)
+	}
+
+	o := jsonNeighbourhood{
+		Segment:    newJSONGraphSegment(s),
+		References: []jsonGraphSegment{},
+		Referrers:  []jsonGraphSegment{},
+	}
+
+	for _, r := range s.References {
+		o.References = append(o.References, newJSONGraphSegment(r))
+	}
+
+	for _, r := range s.Referrers {
+		o.Referrers = append(o.Referrers, newJSONGraphSegment(r))
+	}
+
+	return o, nil
+}
+
+// readGraph returns the graph of the store, reading it the first time it is
+// called. An error reading the graph is returned to every caller.
+func (sv *server) readGraph() (*store.Graph, error) {
+	sv.graphOnce.Do(func() {
+		sv.graph, sv.graphErr = sv.store.ReadGraph()
+	})
+
+	return sv.graph, sv.graphErr
+}
+
+func (sv *server) segment(p string, _ *http.Request) (interface{}, error) {
+	id, err := store.ParseSegmentID(p)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	if sv.store.Tar(id) == nil {
+		return nil, notFound("segment %s not found", id)
+	}
+
+	if isBulkSegmentID(id.String()) {
+		return jsonSegment{
+			SegmentID:  id.String(),
+			Type:       segmentType(id.String()),
+			References: []jsonReference{},
+			Records:    []jsonRecord{},
+		}, nil
+	}
+
+	data, err := sv.store.ReadSegment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var s segment.Segment
+
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return newJSONSegment(id.String(), &s), nil
+}
+
+func (sv *server) record(p string, _ *http.Request) (interface{}, error) {
+	id, err := record.ParseID(p)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	if sv.store.Tar(recordSegmentID(id)) == nil {
+		return nil, notFound("segment %s not found", recordSegmentID(id))
+	}
+
+	t, err := sv.reader.Type(id)
+	if err != nil {
+		return nil, notFound("%v", err)
+	}
+
+	size, err := sv.reader.Size(id)
+	if err != nil {
+		return nil, err
+	}
+
+	o := jsonRecordInfo{
+		RecordID:  id.String(),
+		SegmentID: recordSegmentID(id).String(),
+		Type:      recordType(t),
+		Size:      size,
+	}
+
+	switch t {
+	case segment.RecordTypeNode:
+		n, err := sv.reader.ReadNode(id)
+		if err != nil {
+			return nil, err
+		}
+		o.Node = newJSONNodeRecord(n)
+	case segment.RecordTypeValue:
+		length, err := sv.reader.ValueLength(id)
+		if err != nil {
+			return nil, err
+		}
+		o.Length = &length
+	case segment.RecordTypeBlobID:
+		if o.BlobID, err = sv.reader.ReadBlobID(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+func (sv *server) journal(_ string, _ *http.Request) (interface{}, error) {
+	entries, err := sv.store.ReadJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []jsonJournalEntry{}
+
+	for _, e := range entries {
+		o := jsonJournalEntry{RecordID: e.Root.String()}
+		if !e.Time.IsZero() {
+			o.Time = e.Time.UTC().Format(time.RFC3339Nano)
+		}
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
+
+// node returns the node at a path relative to the super root of a revision.
+// The revision is the head, unless the ID of the super root of another
+// revision is specified by the 'root' parameter.
+func (sv *server) node(p string, r *http.Request) (interface{}, error) {
+	var (
+		root record.ID
+		err  error
+	)
+
+	if s := r.URL.Query().Get("root"); s != "" {
+		if root, err = record.ParseID(s); err != nil {
+			return nil, badRequest(err)
+		}
+	} else if root, err = sv.store.Head(); err != nil {
+		return nil, fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	path := cleanPath(p)
+
+	id, err := readPath(sv.reader, root, path)
+	if err != nil {
+		return nil, notFound("%v", err)
+	}
+
+	n, err := sv.reader.ReadNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	o := jsonNode{
+		Path:       path,
+		RecordID:   id.String(),
+		Children:   newJSONChildRecords(n.Children),
+		Properties: []jsonProperty{},
+	}
+
+	for _, p := range n.Properties {
+		po := jsonProperty{
+			Name:   p.Name,
+			Type:   p.Type.String(),
+			Array:  p.Array,
+			Values: []jsonValue{},
+		}
+		for _, v := range p.Values {
+			jv, err := sv.value(v, p.Type)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read property %s: %v", p.Name, err)
+			}
+			po.Values = append(po.Values, jv)
+		}
+		o.Properties = append(o.Properties, po)
+	}
+
+	return o, nil
+}
+
+// value reads the value record 'id' of a property of type 't'.
+func (sv *server) value(id record.ID, t record.Type) (jsonValue, error) {
+	o := jsonValue{RecordID: id.String()}
+
+	if t != record.TypeBinary {
+		s, err := sv.reader.ReadString(id)
+		if err != nil {
+			return o, err
+		}
+		o.Value = &s
+		return o, nil
+	}
+
+	rt, err := sv.reader.Type(id)
+	if err != nil {
+		return o, err
+	}
+
+	if rt == segment.RecordTypeBlobID {
+		o.BlobID, err = sv.reader.ReadBlobID(id)
+		return o, err
+	}
+
+	n, err := sv.reader.ValueLength(id)
+	if err != nil {
+		return o, err
+	}
+
+	length := int64(n)
+	o.Length = &length
+
+	return o, nil
+}
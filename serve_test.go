@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"./store"
+)
+
+func TestServeConcurrent(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        1,
+		tars:        1,
+		generations: 1,
+		depth:       2,
+		fanout:      2,
+		properties:  3,
+		binaries:    2,
+		journal:     1,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	ts := httptest.NewServer(newServer(s).handler())
+	defer ts.Close()
+
+	paths := []string{
+		"/api/graph",
+		"/api/nodes/root",
+		"/api/nodes/root/node0",
+		"/api/nodes/root/node1",
+		"/api/nodes/root/node0/node1",
+	}
+
+	get := func(path string) (string, error) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %d: %s", resp.StatusCode, body)
+		}
+
+		return string(body), nil
+	}
+
+	// The responses are served concurrently first, and compared with the
+	// responses served sequentially afterwards.
+
+	var (
+		wg     sync.WaitGroup
+		bodies = make([]string, 4*len(paths))
+		errs   = make([]error, len(bodies))
+	)
+
+	for i := range bodies {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], errs[i] = get(paths[i%len(paths)])
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i, body := range bodies {
+		path := paths[i%len(paths)]
+
+		if errs[i] != nil {
+			t.Fatalf("unable to get %s: %v", path, errs[i])
+		}
+
+		want, err := get(path)
+		if err != nil {
+			t.Fatalf("unable to get %s: %v", path, err)
+		}
+
+		if body != want {
+			t.Fatalf("responses for %s don't match:\n%s\n%s", path, body, want)
+		}
+	}
+}
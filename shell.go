@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"./record"
+	"./segment"
+	"./store"
+)
+
+// shell is an interactive session on a store. The indexes of the store are
+// read once, and the segments read by the commands are kept in memory.
+type shell struct {
+	store  *store.Store
+	reader *record.Reader
+	head   record.ID
+	cwd    string
+	graph  *store.Graph
+	out    io.Writer
+}
+
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(sh *shell, args []string) error
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"cd":      {"cd [path]", "Changes the current node", (*shell).cd},
+		"ls":      {"ls [path]", "Lists the children and the properties of a node", (*shell).ls},
+		"cat":     {"cat path", "Prints the values of a property", (*shell).cat},
+		"segment": {"segment id", "Prints the header, references and records of a segment", (*shell).segment},
+		"record":  {"record id", "Prints the type and the size of a record, and the content of node records", (*shell).record},
+		"refs":    {"refs id", "Prints the segments referenced by and referencing a segment", (*shell).refs},
+		"journal": {"journal", "Prints the revisions in the journal", (*shell).journal},
+		"help":    {"help", "Prints the available commands", (*shell).help},
+		"exit":    {"exit", "Exits the shell", nil},
+	}
+}
+
+// runShell opens the store in 'directory' and runs commands read from 'in'
+// until the end of the input or the exit command. If 'in' is a terminal, lines
+// can be edited and completed with the Tab key.
+func runShell(directory string, in *os.File, out io.Writer) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	sh := &shell{
+		store:  s,
+		reader: newRecordReader(s),
+		head:   head,
+		cwd:    "/",
+		out:    out,
+	}
+
+	e := &lineEditor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		complete: sh.complete,
+	}
+
+	if restore, err := makeRaw(int(in.Fd())); err == nil {
+		defer restore()
+		e.raw = true
+	}
+
+	for {
+		e.prompt = sh.cwd + "> "
+
+		line, err := e.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		args := splitWords(line)
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "exit" {
+			return nil
+		}
+
+		c, ok := shellCommands[args[0]]
+		if !ok {
+			fmt.Fprintf(out, "Unknown command '%s'.\n", args[0])
+			continue
+		}
+
+		if err := c.run(sh, args[1:]); err != nil {
+			fmt.Fprintf(out, "Unable to run %s: %v.\n", args[0], err)
+		}
+	}
+}
+
+// resolve returns the absolute path of 'p', relative to the current node.
+func (sh *shell) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = sh.cwd + "/" + p
+	}
+
+	var names []string
+
+	for _, name := range strings.Split(p, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if len(names) > 0 {
+				names = names[:len(names)-1]
+			}
+		default:
+			names = append(names, name)
+		}
+	}
+
+	return "/" + strings.Join(names, "/")
+}
+
+// readNode reads the node at path 'p', relative to the current node.
+func (sh *shell) readNode(p string) (*record.NodeRecord, error) {
+	id, err := readPath(sh.reader, sh.head, sh.resolve(p))
+	if err != nil {
+		return nil, err
+	}
+	return sh.reader.ReadNode(id)
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	p := "/"
+
+	if len(args) == 1 {
+		p = sh.resolve(args[0])
+	}
+
+	if _, err := readPath(sh.reader, sh.head, p); err != nil {
+		return err
+	}
+
+	sh.cwd = p
+
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	p := "."
+
+	if len(args) == 1 {
+		p = args[0]
+	}
+
+	n, err := sh.readNode(p)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range n.Children {
+		fmt.Fprintf(sh.out, "%s/\n", c.Name)
+	}
+
+	for _, p := range n.Properties {
+		if p.Array {
+			fmt.Fprintf(sh.out, "%s %s[%d]\n", p.Name, p.Type, len(p.Values))
+		} else {
+			fmt.Fprintf(sh.out, "%s %s\n", p.Name, p.Type)
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) cat(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a property must be specified")
+	}
+
+	var (
+		p    = sh.resolve(args[0])
+		i    = strings.LastIndex(p, "/")
+		name = p[i+1:]
+	)
+
+	n, err := sh.readNode(p[:i+1])
+	if err != nil {
+		return err
+	}
+
+	for _, prop := range n.Properties {
+		if prop.Name != name {
+			continue
+		}
+		for _, id := range prop.Values {
+			v, err := sh.reader.ReadValue(id, prop.Type)
+			if err != nil {
+				return err
+			}
+			switch {
+			case v.BlobID != "":
+				fmt.Fprintf(sh.out, "blob %s\n", v.BlobID)
+			case v.Binary != nil:
+				sh.out.Write(v.Binary)
+				fmt.Fprintln(sh.out)
+			default:
+				fmt.Fprintln(sh.out, v.String)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("property %s not found", p)
+}
+
+func (sh *shell) segment(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a segment ID must be specified")
+	}
+
+	id, err := store.ParseSegmentID(args[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := sh.store.ReadSegment(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "tar %s\n", sh.store.Tar(id).Name)
+	fmt.Fprintf(sh.out, "size %d\n", len(data))
+
+	if isBulkSegmentID(id.String()) {
+		return nil
+	}
+
+	return doPrintSegmentTo(sh.out)(id.String(), bytes.NewReader(data))
+}
+
+func (sh *shell) record(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a record ID must be specified")
+	}
+
+	id, err := record.ParseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	t, err := sh.reader.Type(id)
+	if err != nil {
+		return err
+	}
+
+	size, err := sh.reader.Size(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "type %s\n", recordType(t))
+	fmt.Fprintf(sh.out, "size %d\n", size)
+
+	switch t {
+	case segment.RecordTypeNode:
+		n, err := sh.reader.ReadNode(id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "stableId %s\n", n.StableID)
+		fmt.Fprintf(sh.out, "template %s\n", n.Template)
+		for _, c := range n.Children {
+			fmt.Fprintf(sh.out, "child %s %s\n", c.ID, c.Name)
+		}
+		for _, p := range n.Properties {
+			for _, v := range p.Values {
+				fmt.Fprintf(sh.out, "property %s %s %s\n", v, p.Type, p.Name)
+			}
+		}
+	case segment.RecordTypeValue:
+		length, err := sh.reader.ValueLength(id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "length %d\n", length)
+	case segment.RecordTypeBlobID:
+		blobID, err := sh.reader.ReadBlobID(id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "blob %s\n", blobID)
+	}
+
+	return nil
+}
+
+func (sh *shell) refs(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a segment ID must be specified")
+	}
+
+	id, err := store.ParseSegmentID(args[0])
+	if err != nil {
+		return err
+	}
+
+	if sh.graph == nil {
+		if sh.graph, err = sh.store.ReadGraph(); err != nil {
+			return err
+		}
+	}
+
+	s := sh.graph.Segment(id)
+
+	if s == nil {
+		return fmt.Errorf("segment %s is not in the store and is not referenced", id)
+	}
+
+	for _, r := range s.References {
+		fmt.Fprintf(sh.out, "reference %s %s\n", segmentType(r.ID.String()), r.ID)
+	}
+
+	for _, r := range s.Referrers {
+		fmt.Fprintf(sh.out, "referrer %s %s\n", segmentType(r.ID.String()), r.ID)
+	}
+
+	return nil
+}
+
+func (sh *shell) journal(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	entries, err := sh.store.ReadJournal()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			fmt.Fprintf(sh.out, "%s -\n", e.Root)
+		} else {
+			fmt.Fprintf(sh.out, "%s %s\n", e.Root, e.Time.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) help(args []string) error {
+	var names []string
+
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		c := shellCommands[name]
+		fmt.Fprintf(sh.out, "%-12s %s\n", c.usage, c.help)
+	}
+
+	return nil
+}
+
+// complete returns the words that can complete the last word of 'line'. The
+// first word is completed with the names of the commands, and the arguments
+// are completed with paths or segment IDs, depending on the command.
+func (sh *shell) complete(line string) (int, []string) {
+	var (
+		start = lastWordStart(line)
+		args  = splitWords(line[:start])
+		words []string
+	)
+
+	if len(args) == 0 {
+		for name := range shellCommands {
+			words = append(words, name)
+		}
+		return start, words
+	}
+
+	switch args[0] {
+	case "cd", "ls":
+		words = sh.completePath(line[start:], false)
+	case "cat":
+		words = sh.completePath(line[start:], true)
+	case "segment", "refs":
+		for _, t := range sh.store.Tars {
+			for _, e := range t.Index.Entries {
+				words = append(words, segmentID(e.Msb, e.Lsb))
+			}
+		}
+	case "record":
+		for _, t := range sh.store.Tars {
+			for _, e := range t.Index.Entries {
+				if id := (record.ID{Msb: e.Msb, Lsb: e.Lsb}); !isBulkSegmentID(segmentID(e.Msb, e.Lsb)) {
+					words = append(words, strings.SplitAfter(id.String(), ".")[0])
+				}
+			}
+		}
+	}
+
+	return start, words
+}
+
+// completePath returns the paths of the children of the node in 'word', up to
+// the last slash. If 'properties' is true, the paths of its properties are
+// returned too.
+func (sh *shell) completePath(word string, properties bool) []string {
+	var (
+		words  = splitWords(word)
+		prefix string
+	)
+
+	if len(words) == 1 {
+		prefix = words[0]
+	}
+
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	n, err := sh.readNode(dir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+
+	for _, c := range n.Children {
+		paths = append(paths, escapeWord(dir+c.Name+"/"))
+	}
+
+	if properties {
+		for _, p := range n.Properties {
+			paths = append(paths, escapeWord(dir+p.Name))
+		}
+	}
+
+	return paths
+}
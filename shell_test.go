@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"./record"
+	"./store"
+)
+
+func writeShellTestStore(t *testing.T) string {
+	t.Helper()
+
+	directory := t.TempDir()
+
+	writeTestStore(t, directory, 1, &record.Node{
+		Children: []record.Child{
+			{Name: "nœud", Node: &record.Node{
+				Properties: []record.Property{
+					{Name: "titre", Type: record.TypeString, Values: []record.Value{{String: "Bonjour à tous"}}},
+					{Name: "tags", Type: record.TypeString, Array: true, Values: []record.Value{{String: "a"}, {String: "b"}}},
+				},
+				Children: []record.Child{
+					{Name: "enfant", Node: &record.Node{}},
+				},
+			}},
+		},
+	})
+
+	return directory
+}
+
+func TestShell(t *testing.T) {
+	directory := writeShellTestStore(t)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	commands := []string{
+		"ls /root",
+		"cd /root/nœud",
+		"ls",
+		"cat titre",
+		"cat /root/nœud/tags",
+		"cd enfant",
+		"cd ../..",
+		"ls",
+		"cd missing",
+		"cat missing",
+		"unknown",
+		"exit",
+		"ls",
+	}
+
+	if _, err := w.WriteString(strings.Join(commands, "\n") + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var out bytes.Buffer
+
+	if err := runShell(directory, r, &out); err != nil {
+		t.Fatalf("unable to run the shell: %v", err)
+	}
+
+	want := []string{
+		// ls /root
+		"nœud/",
+		// ls
+		"enfant/",
+		"tags String[2]",
+		"titre String",
+		// cat titre
+		"Bonjour à tous",
+		// cat /root/nœud/tags
+		"a",
+		"b",
+		// ls
+		"nœud/",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+
+	if len(lines) != len(want)+3 {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	for i, line := range want {
+		if lines[i] != line {
+			t.Fatalf("unexpected line %d: got %q, want %q\n%s", i, lines[i], line, out.String())
+		}
+	}
+
+	// The commands after exit are not run, and the errors don't stop the
+	// shell.
+
+	for i, prefix := range []string{"Unable to run cd: ", "Unable to run cat: ", "Unknown command 'unknown'."} {
+		if line := lines[len(want)+i]; !strings.HasPrefix(line, prefix) {
+			t.Fatalf("unexpected line %q, want prefix %q", line, prefix)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	directory := writeShellTestStore(t)
+
+	s, err := store.Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		t.Fatalf("unable to read the journal: %v", err)
+	}
+
+	sh := &shell{
+		store:  s,
+		reader: newRecordReader(s),
+		head:   head,
+		cwd:    "/",
+		out:    &bytes.Buffer{},
+	}
+
+	tests := []struct {
+		line  string
+		start int
+		words []string
+	}{
+		{"cd /root/", 3, []string{"/root/nœud/"}},
+		{"cd /root/nœud/e", 3, []string{"/root/nœud/enfant/"}},
+		{"cat /root/nœud/t", 4, []string{"/root/nœud/enfant/", "/root/nœud/tags", "/root/nœud/titre"}},
+	}
+
+	for _, test := range tests {
+		start, words := sh.complete(test.line)
+
+		sort.Strings(words)
+
+		if start != test.start || strings.Join(words, " ") != strings.Join(test.words, " ") {
+			t.Fatalf("unexpected completion of %q: got %d %q, want %d %q", test.line, start, words, test.start, test.words)
+		}
+	}
+
+	// The first word is completed with the commands.
+
+	start, words := sh.complete("ca")
+
+	if start != 0 || len(words) != len(shellCommands) {
+		t.Fatalf("unexpected completion of commands: %d %q", start, words)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"./binaries"
+	"./index"
+)
+
+// statsSizeBuckets are the upper bounds of the buckets of the segment size
+// distribution.
+var statsSizeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 128 << 10, 192 << 10, 256 << 10}
+
+type statsCount struct {
+	count int
+	size  int64
+}
+
+func (c *statsCount) add(size int64) {
+	c.count++
+	c.size += size
+}
+
+// printStats prints a summary of the TAR files in 'directory', built from the
+// size of the TAR files, their indexes and their binary references.
+func printStats(directory string, all bool, w io.Writer) error {
+	var (
+		total       statsCount
+		tars        []string
+		tarSizes    = make(map[string]int64)
+		letters     = make(map[byte]*statsCount)
+		data        statsCount
+		bulk        statsCount
+		sizes       = make([]int, len(statsSizeBuckets))
+		generations = make(map[int]int)
+		fulls       = make(map[int]int)
+		compacted   = make(map[bool]int)
+		references  int
+	)
+
+	err := forEachTarFile(directory, all, func(name string) error {
+		p := filepath.Join(directory, name)
+
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+
+		tars = append(tars, name)
+		tarSizes[name] = info.Size()
+		total.add(info.Size())
+
+		letter := name[len(name)-len("a.tar")]
+
+		if letters[letter] == nil {
+			letters[letter] = &statsCount{}
+		}
+
+		letters[letter].add(info.Size())
+
+		err = onMatchingEntry(p, isIndex, func(_ string, r io.Reader) error {
+			var idx index.Index
+			if _, err := idx.ReadFrom(r); err != nil {
+				return err
+			}
+			for _, e := range idx.Entries {
+				if isBulkSegmentID(segmentID(e.Msb, e.Lsb)) {
+					bulk.add(int64(e.Size))
+				} else {
+					data.add(int64(e.Size))
+				}
+				sizes[statsSizeBucket(e.Size)]++
+				generations[e.Generation]++
+				fulls[e.FullGeneration]++
+				compacted[e.Compacted]++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to read the index of %s: %v", name, err)
+		}
+
+		err = onMatchingEntry(p, isBinary, func(_ string, r io.Reader) error {
+			var bns binaries.Binaries
+			if _, err := bns.ReadFrom(r); err != nil {
+				return err
+			}
+			for _, g := range bns.Generations {
+				for _, s := range g.Segments {
+					references += len(s.References)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to read the binary references of %s: %v", name, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tars {
+		fmt.Fprintf(w, "tar %s %d\n", name, tarSizes[name])
+	}
+
+	var keys []byte
+
+	for l := range letters {
+		keys = append(keys, l)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, l := range keys {
+		fmt.Fprintf(w, "tars %c %d %d\n", l, letters[l].count, letters[l].size)
+	}
+
+	fmt.Fprintf(w, "total %d %d\n", total.count, total.size)
+	fmt.Fprintf(w, "segments data %d %d\n", data.count, data.size)
+	fmt.Fprintf(w, "segments bulk %d %d\n", bulk.count, bulk.size)
+
+	for i, b := range statsSizeBuckets {
+		fmt.Fprintf(w, "size %d %d\n", b, sizes[i])
+	}
+
+	for _, g := range sortedKeys(generations) {
+		fmt.Fprintf(w, "generation %d %d\n", g, generations[g])
+	}
+
+	for _, g := range sortedKeys(fulls) {
+		fmt.Fprintf(w, "fullGeneration %d %d\n", g, fulls[g])
+	}
+
+	fmt.Fprintf(w, "compacted false %d\n", compacted[false])
+	fmt.Fprintf(w, "compacted true %d\n", compacted[true])
+	fmt.Fprintf(w, "binaries %d\n", references)
+
+	return nil
+}
+
+// statsSizeBucket returns the index of the smallest bucket that can contain a
+// segment of size 'size'.
+func statsSizeBucket(size int) int {
+	for i, b := range statsSizeBuckets {
+		if size <= b {
+			return i
+		}
+	}
+	return len(statsSizeBuckets) - 1
+}
+
+func sortedKeys(m map[int]int) []int {
+	var keys []int
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"./archive"
+	"./segment"
+)
+
+// testTarSegment is a segment to write to a TAR file, with its generation
+// information and the binary references from it.
+type testTarSegment struct {
+	msb, lsb       uint64
+	generation     int
+	fullGeneration int
+	compacted      bool
+	data           []byte
+	binaries       []string
+}
+
+// testDataSegment returns the content of a data segment containing records of
+// the given types and sizes.
+func testDataSegment(t *testing.T, generation int, records ...testRecord) []byte {
+	t.Helper()
+
+	builder := &segment.Builder{Version: 13, Generation: generation, FullGeneration: generation}
+
+	for _, r := range records {
+		if _, err := builder.AddRecord(r.t, bytes.Repeat([]byte{1}, r.size)); err != nil {
+			t.Fatalf("unable to add the record: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+
+	if _, err := builder.WriteTo(&b); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	return b.Bytes()
+}
+
+type testRecord struct {
+	t    segment.RecordType
+	size int
+}
+
+// writeTestTar writes the segments to a TAR file at 'path'.
+func writeTestTar(t *testing.T, path string, segments []testTarSegment) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := archive.NewWriter(f, filepath.Base(path))
+
+	for _, s := range segments {
+		if err := w.WriteSegment(s.msb, s.lsb, s.generation, s.fullGeneration, s.compacted, s.data); err != nil {
+			t.Fatalf("unable to write the segment: %v", err)
+		}
+		for _, b := range s.binaries {
+			if err := w.AddBinaryReference(s.msb, s.lsb, b); err != nil {
+				t.Fatalf("unable to add the binary reference: %v", err)
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close the TAR file: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	directory := t.TempDir()
+
+	var (
+		a = testTarSegment{
+			msb:            1,
+			lsb:            0xa000000000000001,
+			generation:     1,
+			fullGeneration: 1,
+			data:           testDataSegment(t, 1, testRecord{segment.RecordTypeNode, 12}),
+			binaries:       []string{"0123456789#1", "abcdef0123#2"},
+		}
+		b = testTarSegment{
+			msb:            2,
+			lsb:            0xa000000000000002,
+			generation:     2,
+			fullGeneration: 2,
+			compacted:      true,
+			data:           testDataSegment(t, 2, testRecord{segment.RecordTypeValue, 2000}),
+		}
+		c = testTarSegment{
+			msb:            3,
+			lsb:            0xb000000000000003,
+			generation:     2,
+			fullGeneration: 2,
+			compacted:      true,
+			data:           make([]byte, 20000),
+		}
+	)
+
+	writeTestTar(t, filepath.Join(directory, "data00000a.tar"), []testTarSegment{a, c})
+	writeTestTar(t, filepath.Join(directory, "data00001a.tar"), []testTarSegment{b})
+
+	var out bytes.Buffer
+
+	if err := printStats(directory, false, &out); err != nil {
+		t.Fatalf("unable to print the statistics: %v", err)
+	}
+
+	size := func(name string) int64 {
+		info, err := os.Stat(filepath.Join(directory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	var (
+		s0 = size("data00000a.tar")
+		s1 = size("data00001a.tar")
+	)
+
+	want := []string{
+		fmt.Sprintf("tar data00000a.tar %d", s0),
+		fmt.Sprintf("tar data00001a.tar %d", s1),
+		fmt.Sprintf("tars a 2 %d", s0+s1),
+		fmt.Sprintf("total 2 %d", s0+s1),
+		fmt.Sprintf("segments data 2 %d", len(a.data)+len(b.data)),
+		"segments bulk 1 20000",
+		"size 1024 1",
+		"size 4096 1",
+		"size 16384 0",
+		"size 65536 1",
+		"size 131072 0",
+		"size 196608 0",
+		"size 262144 0",
+		"generation 1 1",
+		"generation 2 2",
+		"fullGeneration 1 1",
+		"fullGeneration 2 2",
+		"compacted false 1",
+		"compacted true 2",
+		"binaries 2",
+	}
+
+	if got := strings.TrimSpace(out.String()); got != strings.Join(want, "\n") {
+		t.Fatalf("unexpected statistics:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncated is returned when an entry of a TAR file, or the data of a
+	// segment at the position recorded in the index, ends before the end of
+	// the file.
+	ErrTruncated = errors.New("truncated entry")
+	// ErrNotFound is returned when a segment is not in the index of any TAR
+	// file.
+	ErrNotFound = errors.New("segment not found")
+)
+
+// EntryError describes why an entry of a TAR file couldn't be read. Err is
+// ErrTruncated and can be tested with errors.Is. Tar is the name of the TAR
+// file, Entry the name of the entry or the ID of the segment, and Offset the
+// position of the content of the entry in the TAR file, or -1 if the TAR file
+// ends in the middle of a header.
+type EntryError struct {
+	Err    error
+	Tar    string
+	Entry  string
+	Offset int64
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%v %s in %s at offset %d", e.Err, e.Entry, e.Tar, e.Offset)
+}
+
+// Unwrap returns Err.
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+
+	"../graph"
+	"../segment"
+)
+
+// Graph is the graph of references between the segments of a store, combined
+// from the graphs of every active TAR file. It contains every segment in the
+// index of an active TAR file, and every segment referenced by them.
+type Graph struct {
+	// Segments are ordered by TAR file and by their position in the index.
+	// Missing segments follow, in the order they are first referenced.
+	Segments []*GraphSegment
+
+	byID map[SegmentID]*GraphSegment
+}
+
+// GraphSegment is a segment in the graph of a store.
+type GraphSegment struct {
+	ID SegmentID
+
+	// Tar is the name of the TAR file containing the segment. It is empty if
+	// the segment is missing.
+	Tar string
+
+	Generation     int
+	FullGeneration int
+	Compacted      bool
+
+	// Missing is true if the segment is not in the index of any active TAR
+	// file. References to a missing segment are dangling.
+	Missing bool
+
+	// References are the segments referenced by this segment.
+	References []*GraphSegment
+
+	// Referrers are the segments referencing this segment.
+	Referrers []*GraphSegment
+}
+
+// InDegree returns the number of segments referencing the segment.
+func (s *GraphSegment) InDegree() int {
+	return len(s.Referrers)
+}
+
+// OutDegree returns the number of segments referenced by the segment.
+func (s *GraphSegment) OutDegree() int {
+	return len(s.References)
+}
+
+// Segment returns the segment identified by 'id', or nil if the segment is not
+// in the graph.
+func (g *Graph) Segment(id SegmentID) *GraphSegment {
+	return g.byID[id]
+}
+
+// Dangling returns the missing segments in the graph.
+func (g *Graph) Dangling() []*GraphSegment {
+	var dangling []*GraphSegment
+	for _, s := range g.Segments {
+		if s.Missing {
+			dangling = append(dangling, s)
+		}
+	}
+	return dangling
+}
+
+// Reachable returns the segments reachable from the segments 'from', including
+// them, in breadth-first order. Nil segments are ignored.
+func (g *Graph) Reachable(from ...*GraphSegment) []*GraphSegment {
+	var (
+		reachable []*GraphSegment
+		seen      = make(map[*GraphSegment]bool)
+	)
+
+	visit := func(s *GraphSegment) {
+		if s != nil && !seen[s] {
+			seen[s] = true
+			reachable = append(reachable, s)
+		}
+	}
+
+	for _, s := range from {
+		visit(s)
+	}
+
+	for i := 0; i < len(reachable); i++ {
+		for _, r := range reachable[i].References {
+			visit(r)
+		}
+	}
+
+	return reachable
+}
+
+// ReadGraph combines the graphs of the active TAR files into a single graph.
+// If a segment is in the index of more than one TAR file, the first TAR file
+// takes precedence. If a TAR file doesn't contain a graph, its graph is
+// rebuilt from the reference tables of its data segments.
+func (s *Store) ReadGraph() (*Graph, error) {
+	g := &Graph{byID: make(map[SegmentID]*GraphSegment)}
+
+	for _, t := range s.Tars {
+		for _, e := range t.Index.Entries {
+			id := SegmentID{e.Msb, e.Lsb}
+			if g.byID[id] != nil {
+				continue
+			}
+			g.add(&GraphSegment{
+				ID:             id,
+				Tar:            t.Name,
+				Generation:     e.Generation,
+				FullGeneration: e.FullGeneration,
+				Compacted:      e.Compacted,
+			})
+		}
+	}
+
+	for _, t := range s.Tars {
+		var tg graph.Graph
+		ok, err := t.ReadGraph(&tg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the graph of %s: %w", t.Name, err)
+		}
+		if !ok {
+			if err := s.readReferences(t, &tg); err != nil {
+				return nil, fmt.Errorf("unable to read the references of %s: %w", t.Name, err)
+			}
+		}
+		for _, e := range tg.Entries {
+			from := g.segment(SegmentID{e.Msb, e.Lsb})
+			for _, r := range e.References {
+				g.addReference(from, g.segment(SegmentID{r.Msb, r.Lsb}))
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// readReferences adds to 'g' the references from the data segments in the
+// index of 't'.
+func (s *Store) readReferences(t *Tar, g *graph.Graph) error {
+	for _, e := range t.Index.Entries {
+		id := SegmentID{e.Msb, e.Lsb}
+
+		if !id.isData() {
+			continue
+		}
+
+		data, err := s.ReadSegment(id)
+		if err != nil {
+			return err
+		}
+
+		var sg segment.Segment
+
+		if _, err := sg.ReadFrom(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("unable to read segment %s: %w", id, err)
+		}
+
+		var references []graph.Reference
+
+		for _, r := range sg.References {
+			references = append(references, graph.Reference(r))
+		}
+
+		g.AddReferences(id.Msb, id.Lsb, references)
+	}
+
+	return nil
+}
+
+func (g *Graph) add(s *GraphSegment) {
+	g.Segments = append(g.Segments, s)
+	g.byID[s.ID] = s
+}
+
+// segment returns the segment identified by 'id', adding it to the graph as a
+// missing segment if necessary.
+func (g *Graph) segment(id SegmentID) *GraphSegment {
+	if s := g.byID[id]; s != nil {
+		return s
+	}
+	s := &GraphSegment{ID: id, Missing: true}
+	g.add(s)
+	return s
+}
+
+func (g *Graph) addReference(from, to *GraphSegment) {
+	for _, r := range from.References {
+		if r == to {
+			return
+		}
+	}
+	from.References = append(from.References, to)
+	to.Referrers = append(to.Referrers, from)
+}
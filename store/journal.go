@@ -0,0 +1,79 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../record"
+)
+
+// JournalEntry is a revision of the content tree recorded in the journal.
+type JournalEntry struct {
+	// Root is the ID of the super root of the revision.
+	Root record.ID
+	// Time is when the revision was recorded, if known.
+	Time time.Time
+}
+
+// ReadJournal reads the entries of the journal, from the oldest to the most
+// recent one. Lines that can't be parsed are skipped, as the last line of the
+// journal may have been partially written.
+func (s *Store) ReadJournal() ([]JournalEntry, error) {
+	f, err := os.Open(filepath.Join(s.Directory, "journal.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 || fields[1] != "root" {
+			continue
+		}
+
+		id, err := record.ParseID(fields[0])
+		if err != nil {
+			continue
+		}
+
+		e := JournalEntry{Root: id}
+
+		if len(fields) > 2 {
+			if ms, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				e.Time = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Head returns the super root of the most recent revision in the journal.
+func (s *Store) Head() (record.ID, error) {
+	entries, err := s.ReadJournal()
+	if err != nil {
+		return record.ID{}, err
+	}
+
+	if len(entries) == 0 {
+		return record.ID{}, fmt.Errorf("empty journal")
+	}
+
+	return entries[len(entries)-1].Root, nil
+}
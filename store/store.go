@@ -0,0 +1,202 @@
+package store
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"../binaries"
+	"../graph"
+	"../index"
+)
+
+// SegmentID is the identifier of a segment.
+type SegmentID struct {
+	Msb uint64
+	Lsb uint64
+}
+
+var segmentIDRegexp = regexp.MustCompile("^([0-9a-f]{8})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{12})$")
+
+// ParseSegmentID parses a segment ID, with or without dashes.
+func ParseSegmentID(s string) (SegmentID, error) {
+	m := segmentIDRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+
+	if m == nil {
+		return SegmentID{}, fmt.Errorf("invalid segment ID '%s'", s)
+	}
+
+	var (
+		msb, _ = strconv.ParseUint(m[1]+m[2]+m[3], 16, 64)
+		lsb, _ = strconv.ParseUint(m[4]+m[5], 16, 64)
+	)
+
+	return SegmentID{msb, lsb}, nil
+}
+
+func (id SegmentID) String() string {
+	return fmt.Sprintf("%016x%016x", id.Msb, id.Lsb)
+}
+
+// isData returns true if the ID identifies a data segment. The most significant
+// nibble of the least significant bits of the ID is 0xa for data segments and
+// 0xb for bulk segments.
+func (id SegmentID) isData() bool {
+	return id.Lsb>>60 == 0xa
+}
+
+// Store is a read-only view of the active TAR files of a segment store.
+type Store struct {
+	Directory string
+	Tars      []*Tar
+
+	locations map[SegmentID]location
+}
+
+// location is the position of a segment in a TAR file.
+type location struct {
+	tar   *Tar
+	entry index.Entry
+}
+
+// Tar is an active TAR file of a segment store, together with its index.
+type Tar struct {
+	Name  string
+	Path  string
+	Index index.Index
+}
+
+// Open returns the store in 'directory' and reads the index of every active TAR
+// file. TAR files are ordered by number.
+func Open(directory string) (*Store, error) {
+	names, err := TarFiles(directory, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		Directory: directory,
+		locations: make(map[SegmentID]location),
+	}
+
+	for _, name := range names {
+		t, err := OpenTar(filepath.Join(directory, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range t.Index.Entries {
+			id := SegmentID{e.Msb, e.Lsb}
+			if _, ok := s.locations[id]; !ok {
+				s.locations[id] = location{t, e}
+			}
+		}
+		s.Tars = append(s.Tars, t)
+	}
+
+	return s, nil
+}
+
+// OpenTar returns the TAR file at path 'p' and reads its index.
+func OpenTar(p string) (*Tar, error) {
+	t := &Tar{Name: filepath.Base(p), Path: p}
+
+	if _, err := t.readEntry(".idx", &t.Index); err != nil {
+		return nil, fmt.Errorf("unable to read the index of %s: %w", t.Name, err)
+	}
+
+	return t, nil
+}
+
+// Tar returns the TAR file containing the segment identified by 'id', or nil if
+// the segment is not in any index. If a segment is in the index of more than
+// one TAR file, the first TAR file takes precedence.
+func (s *Store) Tar(id SegmentID) *Tar {
+	return s.locations[id].tar
+}
+
+// ReadSegment returns the content of the segment identified by 'id'. The
+// segment is read from the position recorded in the index of its TAR file.
+func (s *Store) ReadSegment(id SegmentID) ([]byte, error) {
+	l, ok := s.locations[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return l.tar.ReadSegment(l.entry)
+}
+
+// ReadSegment returns the content of the segment described by the index entry
+// 'e'. The segment is read from the position recorded in the entry.
+func (t *Tar) ReadSegment(e index.Entry) ([]byte, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, e.Size)
+
+	if _, err := f.ReadAt(data, int64(e.Position)); err == io.EOF {
+		return nil, &EntryError{Err: ErrTruncated, Tar: t.Name, Entry: SegmentID{e.Msb, e.Lsb}.String(), Offset: int64(e.Position)}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read segment %s from %s: %w", SegmentID{e.Msb, e.Lsb}, t.Name, err)
+	}
+
+	return data, nil
+}
+
+// ReadGraph reads the graph of the TAR file. It returns false if the TAR file
+// doesn't contain a graph.
+func (t *Tar) ReadGraph(g *graph.Graph) (bool, error) {
+	return t.readEntry(".gph", g)
+}
+
+// ReadBinaries reads the binary references index of the TAR file. It returns
+// false if the TAR file doesn't contain a binary references index.
+func (t *Tar) ReadBinaries(b *binaries.Binaries) (bool, error) {
+	return t.readEntry(".brf", b)
+}
+
+// readEntry reads the content of the first entry whose name ends with 'suffix'
+// into 'r'. It returns false if no such entry exists. A TAR file that ends in
+// the middle of a header or of the content of an entry is reported as
+// ErrTruncated.
+func (t *Tar) readEntry(suffix string, r io.ReaderFrom) (bool, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return false, &EntryError{Err: ErrTruncated, Tar: t.Name, Entry: "header", Offset: -1}
+		}
+		if err != nil {
+			return false, err
+		}
+		if strings.HasSuffix(hdr.Name, suffix) {
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return false, err
+			}
+			_, err = r.ReadFrom(tr)
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return false, &EntryError{Err: ErrTruncated, Tar: t.Name, Entry: hdr.Name, Offset: offset}
+			}
+			return err == nil, err
+		}
+	}
+}
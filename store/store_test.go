@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../archive"
+)
+
+func writeTestTar(t *testing.T, directory string) string {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w := archive.NewWriter(&b, "data00000a.tar")
+
+	if err := w.WriteSegment(1, 0xb000000000000002, 0, 0, false, bytes.Repeat([]byte{1}, 4096)); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close the archive: %v", err)
+	}
+
+	p := filepath.Join(directory, "data00000a.tar")
+
+	if err := ioutil.WriteFile(p, b.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write the TAR file: %v", err)
+	}
+
+	return p
+}
+
+func TestOpenTarTruncated(t *testing.T) {
+	p := writeTestTar(t, t.TempDir())
+
+	// Cut the TAR file in the middle of the content of the segment, before
+	// the index.
+	if err := os.Truncate(p, 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenTar(p)
+
+	var ee *EntryError
+
+	if !errors.Is(err, ErrTruncated) || !errors.As(err, &ee) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ee.Tar != "data00000a.tar" {
+		t.Fatalf("unexpected TAR file %s", ee.Tar)
+	}
+}
+
+func TestReadSegmentTruncated(t *testing.T) {
+	p := writeTestTar(t, t.TempDir())
+
+	tar, err := OpenTar(p)
+	if err != nil {
+		t.Fatalf("unable to open the TAR file: %v", err)
+	}
+
+	e := tar.Index.Entries[0]
+	e.Size = 1 << 20
+
+	_, err = tar.ReadSegment(e)
+
+	var ee *EntryError
+
+	if !errors.Is(err, ErrTruncated) || !errors.As(err, &ee) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ee.Offset != int64(e.Position) {
+		t.Fatalf("unexpected offset %d, want %d", ee.Offset, e.Position)
+	}
+}
+
+func TestReadSegmentNotFound(t *testing.T) {
+	directory := t.TempDir()
+
+	writeTestTar(t, directory)
+
+	s, err := Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	if _, err := s.ReadSegment(SegmentID{Msb: 1, Lsb: 0xb000000000000002}); err != nil {
+		t.Fatalf("unable to read the segment: %v", err)
+	}
+
+	if _, err := s.ReadSegment(SegmentID{Msb: 3, Lsb: 4}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
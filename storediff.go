@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"./index"
+	"./store"
+)
+
+// printStoreDiff compares the active TAR files of the stores in 'a' and 'b'. It
+// prints the segments only in 'a' and only in 'b', the TAR files whose indexes
+// differ, and the heads of the journals. Finally, it prints whether 'b'
+// contains every segment reachable from the head of 'a', listing the ones it
+// doesn't contain. Segments reachable from the head of 'a' but already missing
+// in 'a' are listed separately, and are not held against 'b'.
+func printStoreDiff(a, b string, w io.Writer) error {
+	sa, err := store.Open(a)
+	if err != nil {
+		return err
+	}
+
+	sb, err := store.Open(b)
+	if err != nil {
+		return err
+	}
+
+	var (
+		missing int
+		extra   int
+	)
+
+	forEachStoreSegment(sa, func(t *store.Tar, e index.Entry) {
+		if id := (store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}); sb.Tar(id) == nil {
+			fmt.Fprintf(w, "missing %s %s %s\n", segmentType(id.String()), id, t.Name)
+			missing++
+		}
+	})
+
+	forEachStoreSegment(sb, func(t *store.Tar, e index.Entry) {
+		if id := (store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}); sa.Tar(id) == nil {
+			fmt.Fprintf(w, "extra %s %s %s\n", segmentType(id.String()), id, t.Name)
+			extra++
+		}
+	})
+
+	printTarsDiff(sa, sb, w)
+
+	headA, err := sa.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal of %s: %v", a, err)
+	}
+
+	headB := "-"
+
+	if id, err := sb.Head(); err == nil {
+		headB = id.String()
+	}
+
+	fmt.Fprintf(w, "head %s %s\n", headA, headB)
+
+	g, err := sa.ReadGraph()
+	if err != nil {
+		return err
+	}
+
+	var (
+		reachable = g.Reachable(g.Segment(recordSegmentID(headA)))
+		lost      int
+		dangling  int
+	)
+
+	for _, s := range reachable {
+		switch {
+		case s.Missing:
+			fmt.Fprintf(w, "dangling %s %s\n", segmentType(s.ID.String()), s.ID)
+			dangling++
+		case sb.Tar(s.ID) == nil:
+			fmt.Fprintf(w, "lost %s %s\n", segmentType(s.ID.String()), s.ID)
+			lost++
+		}
+	}
+
+	fmt.Fprintf(w, "total %d %d %d %d %d\n", missing, extra, len(reachable), lost, dangling)
+	fmt.Fprintf(w, "superset %v\n", lost == 0)
+
+	return nil
+}
+
+// forEachStoreSegment calls 'f' for every segment in the indexes of the active
+// TAR files of 's'. Segments in more than one index are considered only once,
+// in the first TAR file containing them.
+func forEachStoreSegment(s *store.Store, f func(t *store.Tar, e index.Entry)) {
+	for _, t := range s.Tars {
+		for _, e := range t.Index.Entries {
+			if s.Tar(store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}) == t {
+				f(t, e)
+			}
+		}
+	}
+}
+
+// printTarsDiff prints the TAR files only in 'a' or only in 'b', and the TAR
+// files in both whose indexes don't contain the same segments.
+func printTarsDiff(a, b *store.Store, w io.Writer) {
+	tars := make(map[string]*store.Tar)
+
+	for _, t := range b.Tars {
+		tars[t.Name] = t
+	}
+
+	for _, ta := range a.Tars {
+		tb := tars[ta.Name]
+
+		if tb == nil {
+			fmt.Fprintf(w, "tar a %s %d\n", ta.Name, len(ta.Index.Entries))
+			continue
+		}
+
+		delete(tars, ta.Name)
+
+		if onlyA, onlyB := diffIndexes(ta.Index, tb.Index); onlyA > 0 || onlyB > 0 {
+			fmt.Fprintf(w, "tar changed %s %d %d\n", ta.Name, onlyA, onlyB)
+		}
+	}
+
+	for _, tb := range b.Tars {
+		if tars[tb.Name] != nil {
+			fmt.Fprintf(w, "tar b %s %d\n", tb.Name, len(tb.Index.Entries))
+		}
+	}
+}
+
+// diffIndexes returns the number of segments only in 'a' and only in 'b'.
+func diffIndexes(a, b index.Index) (int, int) {
+	ids := make(map[store.SegmentID]bool)
+
+	for _, e := range a.Entries {
+		ids[store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}] = true
+	}
+
+	onlyB := 0
+
+	for _, e := range b.Entries {
+		id := store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}
+		if ids[id] {
+			delete(ids, id)
+		} else {
+			onlyB++
+		}
+	}
+
+	return len(ids), onlyB
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreDiffDangling(t *testing.T) {
+	var (
+		a = t.TempDir()
+		b = t.TempDir()
+		o = synthOptions{
+			seed:        1,
+			tars:        3,
+			generations: 1,
+			depth:       2,
+			fanout:      3,
+			properties:  3,
+			journal:     3,
+		}
+	)
+
+	for _, directory := range []string{a, b} {
+		if err := synthesize(directory, o); err != nil {
+			t.Fatalf("unable to synthesize the store: %v", err)
+		}
+		if err := os.Remove(filepath.Join(directory, "data00001a.tar")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+
+	if err := printStoreDiff(a, b, &out); err != nil {
+		t.Fatalf("unable to compare the stores: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "\ndangling ") {
+		t.Fatalf("no dangling segments:\n%s", out.String())
+	}
+
+	if strings.Contains(out.String(), "\nlost ") || !strings.Contains(out.String(), "\nsuperset true\n") {
+		t.Fatalf("segments missing in both stores reported as lost:\n%s", out.String())
+	}
+
+	if err := os.Remove(filepath.Join(b, "data00002a.tar")); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+
+	if err := printStoreDiff(a, b, &out); err != nil {
+		t.Fatalf("unable to compare the stores: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "\nlost ") || !strings.Contains(out.String(), "\nsuperset false\n") {
+		t.Fatalf("segments missing only in the second store not reported as lost:\n%s", out.String())
+	}
+}
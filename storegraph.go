@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"./store"
+)
+
+// printStoreGraph prints the graph combined from the active TAR files in
+// 'directory'. The text format prints one line per segment, with its type, ID,
+// TAR file, in-degree and out-degree, followed by one line per reference.
+// References to segments missing from every index are printed as dangling.
+func printStoreGraph(directory string, f format, w io.Writer) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		return err
+	}
+
+	switch f {
+	case formatText:
+		return printStoreGraphText(w, g)
+	case formatJSON:
+		return printStoreGraphJSON(w, g)
+	case formatDOT, formatGraphML:
+		return writeDiagram(f, w, storeDiagram(g))
+	default:
+		return errInvalidFormat
+	}
+}
+
+func printStoreGraphText(w io.Writer, g *store.Graph) error {
+	for _, s := range g.Segments {
+		id := s.ID.String()
+		tar := s.Tar
+		if s.Missing {
+			tar = "-"
+		}
+		fmt.Fprintf(w, "segment %s %s %s %d %d\n", segmentType(id), id, tar, s.InDegree(), s.OutDegree())
+	}
+	for _, s := range g.Segments {
+		for _, r := range s.References {
+			if r.Missing {
+				fmt.Fprintf(w, "dangling %s %s\n", s.ID, r.ID)
+			} else {
+				fmt.Fprintf(w, "reference %s %s\n", s.ID, r.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func printStoreGraphJSON(w io.Writer, g *store.Graph) error {
+	e := json.NewEncoder(w)
+	for _, s := range g.Segments {
+		if err := e.Encode(newJSONGraphSegment(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newJSONGraphSegment(s *store.GraphSegment) jsonGraphSegment {
+	id := s.ID.String()
+	o := jsonGraphSegment{
+		SegmentID:      id,
+		Type:           segmentType(id),
+		Tar:            s.Tar,
+		Generation:     s.Generation,
+		FullGeneration: s.FullGeneration,
+		Compacted:      s.Compacted,
+		Missing:        s.Missing,
+		InDegree:       s.InDegree(),
+		OutDegree:      s.OutDegree(),
+		References:     []string{},
+		Dangling:       []string{},
+	}
+	for _, r := range s.References {
+		o.References = append(o.References, r.ID.String())
+		if r.Missing {
+			o.Dangling = append(o.Dangling, r.ID.String())
+		}
+	}
+	return o
+}
+
+// storeDiagram converts the graph of a store to a diagram. References are
+// crossing TAR files when the referenced segment is in a different TAR file or
+// is missing.
+func storeDiagram(g *store.Graph) *diagram {
+	d := newDiagram()
+	for _, s := range g.Segments {
+		d.addNode(diagramNode{
+			id:             s.ID.String(),
+			tar:            s.Tar,
+			generation:     s.Generation,
+			fullGeneration: s.FullGeneration,
+			missing:        s.Missing,
+		})
+	}
+	for _, s := range g.Segments {
+		for _, r := range s.References {
+			d.addEdge(s.ID.String(), r.ID.String())
+		}
+	}
+	return d
+}
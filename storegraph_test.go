@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"./store"
+)
+
+// storeGraphText is the parsed text output of printStoreGraph.
+type storeGraphText struct {
+	tars       map[string]string
+	inDegrees  map[string]int
+	outDegrees map[string]int
+	references map[string]int
+	referrers  map[string]int
+	dangling   map[string]bool
+}
+
+func readStoreGraphText(t *testing.T, directory string) *storeGraphText {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	if err := printStoreGraph(directory, formatText, &b); err != nil {
+		t.Fatalf("unable to print the graph: %v", err)
+	}
+
+	g := &storeGraphText{
+		tars:       make(map[string]string),
+		inDegrees:  make(map[string]int),
+		outDegrees: make(map[string]int),
+		references: make(map[string]int),
+		referrers:  make(map[string]int),
+		dangling:   make(map[string]bool),
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var kind, typ, id, tar, from, to string
+		var in, out int
+
+		switch {
+		case strings.HasPrefix(line, "segment "):
+			if _, err := fmt.Sscan(line, &kind, &typ, &id, &tar, &in, &out); err != nil {
+				t.Fatalf("invalid line %q: %v", line, err)
+			}
+			g.tars[id] = tar
+			g.inDegrees[id] = in
+			g.outDegrees[id] = out
+		case strings.HasPrefix(line, "reference "), strings.HasPrefix(line, "dangling "):
+			if _, err := fmt.Sscan(line, &kind, &from, &to); err != nil {
+				t.Fatalf("invalid line %q: %v", line, err)
+			}
+			g.references[from]++
+			g.referrers[to]++
+			if kind == "dangling" {
+				g.dangling[to] = true
+			}
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+
+	// The degrees of every segment match its references.
+
+	for id := range g.tars {
+		if g.inDegrees[id] != g.referrers[id] || g.outDegrees[id] != g.references[id] {
+			t.Fatalf("degrees of %s don't match its references: %d %d, want %d %d", id, g.inDegrees[id], g.outDegrees[id], g.referrers[id], g.references[id])
+		}
+	}
+
+	return g
+}
+
+func TestStoreGraph(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        1,
+		tars:        3,
+		generations: 1,
+		depth:       2,
+		fanout:      3,
+		properties:  3,
+		binaries:    4,
+		bulkRatio:   0.3,
+		journal:     3,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	g := readStoreGraphText(t, directory)
+
+	if len(g.dangling) > 0 {
+		t.Fatalf("unexpected dangling references to %v", g.dangling)
+	}
+
+	edges := 0
+
+	for id, tar := range g.tars {
+		if tar == "-" {
+			t.Fatalf("segment %s reported as missing", id)
+		}
+		edges += g.outDegrees[id]
+	}
+
+	if edges == 0 {
+		t.Fatalf("no references between segments")
+	}
+
+	// The segments of a removed TAR file are missing, and every reference to
+	// them is dangling.
+
+	removed, err := store.OpenTar(filepath.Join(directory, "data00001a.tar"))
+	if err != nil {
+		t.Fatalf("unable to open the TAR file: %v", err)
+	}
+
+	if err := os.Remove(removed.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	inRemoved := make(map[string]bool)
+
+	for _, e := range removed.Index.Entries {
+		inRemoved[store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}.String()] = true
+	}
+
+	g = readStoreGraphText(t, directory)
+
+	if len(g.dangling) == 0 {
+		t.Fatalf("no dangling references")
+	}
+
+	for id, tar := range g.tars {
+		switch {
+		case tar == "-" && !inRemoved[id]:
+			t.Fatalf("segment %s reported as missing", id)
+		case tar == "-" && !g.dangling[id]:
+			t.Fatalf("references to the missing segment %s not reported as dangling", id)
+		case tar != "-" && (inRemoved[id] || g.dangling[id]):
+			t.Fatalf("segment %s not reported as missing", id)
+		case tar == "-" && g.outDegrees[id] != 0:
+			t.Fatalf("missing segment %s has references", id)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"./record"
+	"./segment"
+)
+
+// synthBaseTime is the time of the first revision of a synthetic store.
+var synthBaseTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var synthWords = []string{
+	"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing",
+	"elit", "sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore",
+	"et", "dolore", "magna", "aliqua",
+}
+
+type synthOptions struct {
+	seed        int64
+	tars        int
+	generations int
+	depth       int
+	fanout      int
+	properties  int
+	binaries    int
+	bulkRatio   float64
+	journal     int
+}
+
+func (o synthOptions) validate() error {
+	if o.tars < 1 {
+		return fmt.Errorf("at least one TAR file is required")
+	}
+	if o.generations < 1 {
+		return fmt.Errorf("at least one generation is required")
+	}
+	if o.journal < o.generations {
+		return fmt.Errorf("the journal must have at least one revision per generation")
+	}
+	if o.depth < 0 || o.fanout < 0 || o.properties < 0 || o.binaries < 0 {
+		return fmt.Errorf("the shape of the tree and the number of binaries can't be negative")
+	}
+	if o.bulkRatio < 0 || o.bulkRatio >= 1 {
+		return fmt.Errorf("the bulk ratio must be at least 0 and less than 1")
+	}
+	return nil
+}
+
+// synthesize writes a synthetic segment store to 'directory'. To approximate
+// the ratio of bulk segments, the store is generated once without inline
+// binaries to count the data segments, and then generated again with enough
+// inline binaries to fill the required number of bulk segments. Every inline
+// binary fills one bulk segment, and is written again in every generation.
+func synthesize(directory string, o synthOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	nbulk := 0
+
+	if o.bulkRatio > 0 {
+		sw, err := generateSynthetic(o, 0)
+		if err != nil {
+			return err
+		}
+		ndata := 0
+		for _, s := range sw.segments {
+			if !isBulkSegmentID(segmentID(s.Msb, s.Lsb)) {
+				ndata++
+			}
+		}
+		nbulk = int(math.Round(float64(ndata) * o.bulkRatio / (1 - o.bulkRatio) / float64(o.generations)))
+	}
+
+	sw, err := generateSynthetic(o, nbulk)
+	if err != nil {
+		return err
+	}
+
+	return sw.writeTo(directory, o.tars, synthBaseTime)
+}
+
+// generateSynthetic generates the revisions of a synthetic content tree. The
+// first revision of every generation writes the whole tree, the following ones
+// only modify a few nodes.
+func generateSynthetic(o synthOptions, nbulk int) (*storeWriter, error) {
+	var (
+		r           = rand.New(rand.NewSource(o.seed))
+		sw          = &storeWriter{}
+		paths       [][]string
+		root        = synthNode(r, o, nil, o.depth, &paths)
+		checkpoints = &record.Node{}
+	)
+
+	for i := 0; i < o.binaries; i++ {
+		var (
+			path   = paths[r.Intn(len(paths))]
+			length = 1 + r.Intn(10*1024*1024)
+			blobID = fmt.Sprintf("%016x%016x%08x#%d", r.Uint64(), r.Uint64(), r.Uint32(), length)
+		)
+		root = updateNode(root, path, setProperty(record.Property{
+			Name:   "binary" + strconv.Itoa(i),
+			Type:   record.TypeBinary,
+			Values: []record.Value{{BlobID: blobID}},
+		}))
+	}
+
+	for i := 0; i < nbulk; i++ {
+		var (
+			path = paths[r.Intn(len(paths))]
+			data = make([]byte, segment.MaxSize-r.Intn(segment.MaxSize/4))
+		)
+		r.Read(data)
+		root = updateNode(root, path, setProperty(record.Property{
+			Name:   "data" + strconv.Itoa(i),
+			Type:   record.TypeBinary,
+			Values: []record.Value{{Binary: data}},
+		}))
+	}
+
+	w := record.NewWriter(r, sw.addSegment)
+
+	for i := 0; i < o.journal; i++ {
+		generation := i * o.generations / o.journal
+
+		if generation != w.Generation {
+			w.Generation = generation
+			w.FullGeneration = generation
+			w.Compacted = true
+			w.Reset()
+		} else if i > 0 {
+			for j := 0; j < 1+r.Intn(3); j++ {
+				root = updateNode(root, paths[r.Intn(len(paths))], setProperty(record.Property{
+					Name:   "revision",
+					Type:   record.TypeLong,
+					Values: []record.Value{{String: strconv.Itoa(i)}},
+				}))
+			}
+		}
+
+		w.Time = synthBaseTime.Add(time.Duration(i) * time.Second)
+
+		id, err := w.WriteNode(superRoot(root, checkpoints))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+
+		w.Compacted = false
+
+		sw.addRevision(id, w.Time)
+	}
+
+	return sw, nil
+}
+
+func synthNode(r *rand.Rand, o synthOptions, path []string, depth int, paths *[][]string) *record.Node {
+	*paths = append(*paths, path)
+
+	n := &record.Node{
+		Properties: []record.Property{{
+			Name:   "jcr:primaryType",
+			Type:   record.TypeName,
+			Values: []record.Value{{String: "nt:unstructured"}},
+		}},
+	}
+
+	for i := 0; i < o.properties; i++ {
+		n.Properties = append(n.Properties, synthProperty(r, "prop"+strconv.Itoa(i)))
+	}
+
+	if depth == 0 {
+		return n
+	}
+
+	for i := 0; i < o.fanout; i++ {
+		name := "node" + strconv.Itoa(i)
+		child := append(append([]string(nil), path...), name)
+		n.Children = append(n.Children, record.Child{
+			Name: name,
+			Node: synthNode(r, o, child, depth-1, paths),
+		})
+	}
+
+	return n
+}
+
+func synthProperty(r *rand.Rand, name string) record.Property {
+	switch r.Intn(8) {
+	case 0:
+		return synthValue(name, record.TypeLong, strconv.FormatInt(r.Int63n(1000000), 10))
+	case 1:
+		return synthValue(name, record.TypeBoolean, strconv.FormatBool(r.Intn(2) == 0))
+	case 2:
+		return synthValue(name, record.TypeDouble, strconv.FormatFloat(r.Float64()*1000, 'g', -1, 64))
+	case 3:
+		t := synthBaseTime.Add(-time.Duration(r.Int63n(int64(365 * 24 * time.Hour))))
+		return synthValue(name, record.TypeDate, t.Format("2006-01-02T15:04:05.000Z07:00"))
+	case 4:
+		p := record.Property{Name: name, Type: record.TypeString, Array: true}
+		for i := r.Intn(5); i > 0; i-- {
+			p.Values = append(p.Values, record.Value{String: synthText(r, 1+r.Intn(3))})
+		}
+		return p
+	case 5:
+		if r.Intn(20) == 0 {
+			return synthValue(name, record.TypeString, synthText(r, 5000+r.Intn(5000)))
+		}
+		fallthrough
+	default:
+		return synthValue(name, record.TypeString, synthText(r, 1+r.Intn(20)))
+	}
+}
+
+func synthValue(name string, t record.Type, value string) record.Property {
+	return record.Property{
+		Name:   name,
+		Type:   t,
+		Values: []record.Value{{String: value}},
+	}
+}
+
+func synthText(r *rand.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = synthWords[r.Intn(len(synthWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+// updateNode returns a copy of 'n' where the node at 'path' is modified by 'f'.
+// The nodes along the path are copied, while the rest of the tree is shared.
+func updateNode(n *record.Node, path []string, f func(n *record.Node)) *record.Node {
+	c := &record.Node{
+		Properties: append([]record.Property(nil), n.Properties...),
+		Children:   append([]record.Child(nil), n.Children...),
+	}
+
+	if len(path) == 0 {
+		f(c)
+		return c
+	}
+
+	for i := range c.Children {
+		if c.Children[i].Name == path[0] {
+			c.Children[i].Node = updateNode(c.Children[i].Node, path[1:], f)
+		}
+	}
+
+	return c
+}
+
+// setProperty returns a function that adds a property to a node, or replaces
+// the property with the same name.
+func setProperty(p record.Property) func(n *record.Node) {
+	return func(n *record.Node) {
+		for i := range n.Properties {
+			if n.Properties[i].Name == p.Name {
+				n.Properties[i] = p
+				return
+			}
+		}
+		n.Properties = append(n.Properties, p)
+	}
+}
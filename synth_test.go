@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"./record"
+	"./store"
+)
+
+func TestSynthesize(t *testing.T) {
+	var (
+		directory = t.TempDir()
+		o         = synthOptions{
+			seed:        1,
+			tars:        2,
+			generations: 2,
+			depth:       2,
+			fanout:      3,
+			properties:  4,
+			binaries:    5,
+			bulkRatio:   0.2,
+			journal:     6,
+		}
+	)
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	entries, err := s.ReadJournal()
+	if err != nil {
+		t.Fatalf("unable to read the journal: %v", err)
+	}
+
+	if len(entries) != o.journal {
+		t.Fatalf("unexpected number of revisions: got %d, want %d", len(entries), o.journal)
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		t.Fatalf("unable to read the graph: %v", err)
+	}
+
+	if dangling := g.Dangling(); len(dangling) > 0 {
+		t.Fatalf("unexpected dangling segments %v", dangling)
+	}
+
+	r := newRecordReader(s)
+
+	for _, e := range entries {
+		root, err := readPath(r, e.Root, "/root")
+		if err != nil {
+			t.Fatalf("unable to read the root of revision %v: %v", e.Root, err)
+		}
+
+		var (
+			nodes    int
+			blobs    int
+			binaries int
+		)
+
+		err = walkTree(r, root, "/", func(path string, id record.ID, n *record.NodeRecord, err error) error {
+			if err != nil {
+				return err
+			}
+
+			nodes++
+
+			for _, p := range n.Properties {
+				if p.Type != record.TypeBinary {
+					continue
+				}
+
+				v, err := r.ReadValue(p.Values[0], p.Type)
+				if err != nil {
+					return err
+				}
+
+				switch {
+				case strings.HasPrefix(p.Name, "binary") && v.BlobID != "":
+					blobs++
+				case strings.HasPrefix(p.Name, "data") && len(v.Binary) > 0:
+					binaries++
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("unable to walk revision %v: %v", e.Root, err)
+		}
+
+		if want := 1 + o.fanout + o.fanout*o.fanout; nodes != want {
+			t.Fatalf("unexpected number of nodes in revision %v: got %d, want %d", e.Root, nodes, want)
+		}
+
+		if blobs != o.binaries {
+			t.Fatalf("unexpected number of blobs in revision %v: got %d, want %d", e.Root, blobs, o.binaries)
+		}
+
+		if binaries == 0 {
+			t.Fatalf("no inline binaries in revision %v", e.Root)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"./index"
+	"./store"
+)
+
+// printTarDiff compares the indexes of the TAR files at paths 'a' and 'b'. It
+// prints the segments only in 'a', the segments only in 'b', and the segments
+// in both whose size or generation changed. If 'content' is true, the content
+// of the segments in both TAR files is compared too.
+func printTarDiff(a, b string, content bool, w io.Writer) error {
+	ta, err := store.OpenTar(a)
+	if err != nil {
+		return err
+	}
+
+	tb, err := store.OpenTar(b)
+	if err != nil {
+		return err
+	}
+
+	var (
+		entries = make(map[store.SegmentID]index.Entry)
+		inA     = make(map[store.SegmentID]bool)
+		onlyA   int
+		onlyB   int
+		both    int
+		changed int
+	)
+
+	for _, e := range tb.Index.Entries {
+		entries[store.SegmentID{Msb: e.Msb, Lsb: e.Lsb}] = e
+	}
+
+	for _, ea := range ta.Index.Entries {
+		id := store.SegmentID{Msb: ea.Msb, Lsb: ea.Lsb}
+
+		inA[id] = true
+
+		eb, ok := entries[id]
+
+		if !ok {
+			printTarDiffEntry(w, "a", ea)
+			onlyA++
+			continue
+		}
+
+		both++
+
+		different := false
+
+		if ea.Size != eb.Size {
+			fmt.Fprintf(w, "size %s %s %d %d\n", segmentType(id.String()), id, ea.Size, eb.Size)
+			different = true
+		}
+
+		if ea.Generation != eb.Generation || ea.FullGeneration != eb.FullGeneration || ea.Compacted != eb.Compacted {
+			fmt.Fprintf(w, "generation %s %s %d %d %v %d %d %v\n", segmentType(id.String()), id, ea.Generation, ea.FullGeneration, ea.Compacted, eb.Generation, eb.FullGeneration, eb.Compacted)
+			different = true
+		}
+
+		if content && ea.Size == eb.Size {
+			equal, err := equalSegments(ta, ea, tb, eb)
+			if err != nil {
+				return err
+			}
+			if !equal {
+				fmt.Fprintf(w, "content %s %s\n", segmentType(id.String()), id)
+				different = true
+			}
+		}
+
+		if different {
+			changed++
+		}
+	}
+
+	for _, eb := range tb.Index.Entries {
+		if !inA[store.SegmentID{Msb: eb.Msb, Lsb: eb.Lsb}] {
+			printTarDiffEntry(w, "b", eb)
+			onlyB++
+		}
+	}
+
+	fmt.Fprintf(w, "total %d %d %d %d\n", onlyA, onlyB, both, changed)
+
+	return nil
+}
+
+func printTarDiffEntry(w io.Writer, side string, e index.Entry) {
+	id := segmentID(e.Msb, e.Lsb)
+	fmt.Fprintf(w, "%s %s %s %d %d %d %v\n", side, segmentType(id), id, e.Size, e.Generation, e.FullGeneration, e.Compacted)
+}
+
+// equalSegments returns true if the content of the segment described by 'ea'
+// in 'ta' is equal to the content of the segment described by 'eb' in 'tb'.
+func equalSegments(ta *store.Tar, ea index.Entry, tb *store.Tar, eb index.Entry) (bool, error) {
+	da, err := ta.ReadSegment(ea)
+	if err != nil {
+		return false, err
+	}
+
+	db, err := tb.ReadSegment(eb)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(da, db), nil
+}
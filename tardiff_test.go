@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"./archive"
+	"./index"
+	"./store"
+)
+
+func TestTarDiff(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        1,
+		tars:        1,
+		generations: 2,
+		depth:       2,
+		fanout:      3,
+		properties:  3,
+		binaries:    10,
+		bulkRatio:   0.5,
+		journal:     2,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	a := filepath.Join(directory, "data00000a.tar")
+
+	ta, err := store.OpenTar(a)
+	if err != nil {
+		t.Fatalf("unable to open the TAR file: %v", err)
+	}
+
+	var data, bulk []index.Entry
+
+	for _, e := range ta.Index.Entries {
+		if isBulkSegmentID(segmentID(e.Msb, e.Lsb)) {
+			bulk = append(bulk, e)
+		} else {
+			data = append(data, e)
+		}
+	}
+
+	if len(data) < 2 || len(bulk) < 2 {
+		t.Fatalf("not enough segments: %d data, %d bulk", len(data), len(bulk))
+	}
+
+	// The copy misses a data segment, has a data segment with a different
+	// generation, a bulk segment with a different size, a bulk segment with
+	// a different content, and a segment not in the original.
+
+	var (
+		removed    = data[0]
+		generation = data[1]
+		size       = bulk[0]
+		content    = bulk[1]
+		added      = index.Entry{Msb: 1, Lsb: 0xb000000000000002, Size: 512, Generation: 7, FullGeneration: 7}
+	)
+
+	b := filepath.Join(t.TempDir(), "data00000a.tar")
+
+	f, err := os.Create(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := archive.NewWriter(f, "data00000a.tar")
+
+	for _, e := range ta.Index.Entries {
+		if e == removed {
+			continue
+		}
+
+		d, err := ta.ReadSegment(e)
+		if err != nil {
+			t.Fatalf("unable to read the segment: %v", err)
+		}
+
+		switch e {
+		case generation:
+			e.Generation++
+		case size:
+			d = d[:len(d)/2]
+		case content:
+			d = append([]byte(nil), d...)
+			d[0]++
+		}
+
+		if err := w.WriteSegment(e.Msb, e.Lsb, e.Generation, e.FullGeneration, e.Compacted, d); err != nil {
+			t.Fatalf("unable to write the segment: %v", err)
+		}
+	}
+
+	if err := w.WriteSegment(added.Msb, added.Lsb, added.Generation, added.FullGeneration, added.Compacted, make([]byte, added.Size)); err != nil {
+		t.Fatalf("unable to write the segment: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close the TAR file: %v", err)
+	}
+
+	var out bytes.Buffer
+
+	if err := printTarDiff(a, b, true, &out); err != nil {
+		t.Fatalf("unable to compare the TAR files: %v", err)
+	}
+
+	id := func(e index.Entry) string {
+		return segmentID(e.Msb, e.Lsb)
+	}
+
+	want := []string{
+		fmt.Sprintf("a data %s %d %d %d %v", id(removed), removed.Size, removed.Generation, removed.FullGeneration, removed.Compacted),
+		fmt.Sprintf("generation data %s %d %d %v %d %d %v", id(generation), generation.Generation, generation.FullGeneration, generation.Compacted, generation.Generation+1, generation.FullGeneration, generation.Compacted),
+		fmt.Sprintf("size bulk %s %d %d", id(size), size.Size, size.Size/2),
+		fmt.Sprintf("content bulk %s", id(content)),
+		fmt.Sprintf("b bulk %s 512 7 7 false", id(added)),
+		fmt.Sprintf("total 1 1 %d 3", len(ta.Index.Entries)-1),
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	for _, line := range want {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("no line %q:\n%s", line, out.String())
+		}
+	}
+
+	// Without the content comparison, the segment with a different content
+	// is not reported.
+
+	out.Reset()
+
+	if err := printTarDiff(a, b, false, &out); err != nil {
+		t.Fatalf("unable to compare the TAR files: %v", err)
+	}
+
+	if strings.Contains(out.String(), "content ") || !strings.HasSuffix(out.String(), fmt.Sprintf("\ntotal 1 1 %d 2\n", len(ta.Index.Entries)-1)) {
+		t.Fatalf("unexpected output without content comparison:\n%s", out.String())
+	}
+}
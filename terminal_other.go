@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// makeRaw is not supported on this platform. The shell reads whole lines, and
+// completion is not available.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("unsupported platform")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"./record"
+	"./segment"
+	"./store"
+)
+
+// treeRoot is a root of the content tree that can retain segments: the super
+// root of the head revision, or a checkpoint.
+type treeRoot struct {
+	path string
+	id   record.ID
+}
+
+// readRoots returns the super root 'head' followed by the checkpoints, which
+// are the children of the "checkpoints" node of the super root.
+func readRoots(r *record.Reader, head record.ID) ([]treeRoot, error) {
+	roots := []treeRoot{{"/", head}}
+
+	n, err := r.ReadNode(head)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the super root: %v", err)
+	}
+
+	for _, c := range n.Children {
+		if c.Name != "checkpoints" {
+			continue
+		}
+		checkpoints, err := r.ReadNode(c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the checkpoints: %v", err)
+		}
+		for _, cp := range checkpoints.Children {
+			roots = append(roots, treeRoot{path: "/checkpoints/" + cp.Name, id: cp.ID})
+		}
+	}
+
+	return roots, nil
+}
+
+// newRecordReader returns a reader for the records in the segments of 's'.
+func newRecordReader(s *store.Store) *record.Reader {
+	return record.NewReader(func(msb, lsb uint64) ([]byte, error) {
+		return s.ReadSegment(store.SegmentID{Msb: msb, Lsb: lsb})
+	})
+}
+
+// recordSegmentID returns the ID of the segment containing the record 'id'.
+func recordSegmentID(id record.ID) store.SegmentID {
+	return store.SegmentID{Msb: id.Msb, Lsb: id.Lsb}
+}
+
+// walkTree visits the tree whose root is the node record 'id' in depth-first
+// order, children sorted as they are stored. Node records shared by more than
+// one path are visited only once, at the first path they are found. If a node
+// record can't be read, 'visit' is called with the error and the subtree is
+// skipped. The walk stops when 'visit' returns an error.
+func walkTree(r *record.Reader, id record.ID, path string, visit func(path string, id record.ID, n *record.NodeRecord, err error) error) error {
+	return walkSharedTree(r, id, path, visit, nil)
+}
+
+// walkSharedTree is like walkTree, but calls 'shared', if not nil, with the
+// other paths of the node records shared by more than one path. Their subtrees
+// are not visited again.
+func walkSharedTree(r *record.Reader, id record.ID, path string, visit func(path string, id record.ID, n *record.NodeRecord, err error) error, shared func(path string, id record.ID) error) error {
+	seen := make(map[record.ID]bool)
+
+	var walk func(id record.ID, path string) error
+
+	walk = func(id record.ID, path string) error {
+		if seen[id] {
+			if shared != nil {
+				return shared(path, id)
+			}
+			return nil
+		}
+
+		seen[id] = true
+
+		n, err := r.ReadNode(id)
+
+		if err := visit(path, id, n, err); err != nil {
+			return err
+		}
+
+		if err != nil {
+			return nil
+		}
+
+		for _, c := range n.Children {
+			if err := walk(c.ID, childPath(path, c.Name)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return walk(id, path)
+}
+
+// nodeRecords returns the IDs of the records the node 'n' is made of: the node
+// record, and the templates, lists, maps, values and blocks read to read its
+// properties and the names of its children. Every ID is returned once. The
+// lengths of external binaries are returned too, indexed by the ID of their
+// blob ID record.
+func nodeRecords(r *record.Reader, id record.ID, n *record.NodeRecord) ([]record.ID, map[record.ID]int64, error) {
+	var (
+		ids   []record.ID
+		seen  = make(map[record.ID]bool)
+		blobs = make(map[record.ID]int64)
+	)
+
+	r = r.Trace(func(id record.ID) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	})
+
+	// The node was read before tracing started, and is read again to trace
+	// the records it is made of.
+
+	if _, err := r.ReadNode(id); err != nil {
+		return nil, nil, err
+	}
+
+	for _, p := range n.Properties {
+		for _, v := range p.Values {
+			if err := readTracedValue(r, v, p.Type, blobs); err != nil {
+				return nil, nil, fmt.Errorf("unable to read property %s: %v", p.Name, err)
+			}
+		}
+	}
+
+	return ids, blobs, nil
+}
+
+// readTracedValue reads the value record 'id' of a property of type 't', so
+// that its records are traced. The lengths of external binaries are stored in
+// 'blobs', indexed by the ID of their blob ID record.
+func readTracedValue(r *record.Reader, id record.ID, t record.Type, blobs map[record.ID]int64) error {
+	if t == record.TypeBinary {
+		rt, err := r.Type(id)
+		if err != nil {
+			return err
+		}
+		if rt == segment.RecordTypeBlobID {
+			blobID, err := r.ReadBlobID(id)
+			if err != nil {
+				return err
+			}
+			if length := declaredBlobLength(blobID); length > 0 {
+				blobs[id] = length
+			}
+			return nil
+		}
+	}
+
+	_, err := r.WriteValueTo(id, ioutil.Discard)
+	return err
+}
+
+func childPath(parent, name string) string {
+	if parent == "/" {
+		return "/" + name
+	}
+	return parent + "/" + name
+}
+
+// readPath returns the ID of the node record at 'path', relative to the node
+// record 'root'. The path is made of child names separated by slashes.
+func readPath(r *record.Reader, root record.ID, path string) (record.ID, error) {
+	id := root
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+
+		n, err := r.ReadNode(id)
+		if err != nil {
+			return record.ID{}, err
+		}
+
+		found := false
+
+		for _, c := range n.Children {
+			if c.Name == name {
+				id, found = c.ID, true
+				break
+			}
+		}
+
+		if !found {
+			return record.ID{}, fmt.Errorf("path %s not found", path)
+		}
+	}
+
+	return id, nil
+}
+
+// cleanPath returns 'path' as an absolute path without empty names.
+func cleanPath(path string) string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return "/" + strings.Join(names, "/")
+}
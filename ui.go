@@ -0,0 +1,34 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"./store"
+)
+
+// uiAssets are the static files of the web UI, built into the binary.
+//
+//go:embed ui
+var uiAssets embed.FS
+
+// serveUI opens the store in 'directory' and serves the web UI on 'addr',
+// together with the JSON API the UI is built on.
+func serveUI(directory, addr string) error {
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", newServer(s).handler())
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,304 @@
+'use strict';
+
+// The pages of the UI are selected by the fragment of the URL, and are built
+// from the responses of the JSON API served under /api.
+
+const main = document.getElementById('main');
+
+const svgNS = 'http://www.w3.org/2000/svg';
+
+async function api(path) {
+  const response = await fetch('api/' + path);
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    e.append(c instanceof Node ? c : String(c));
+  }
+  return e;
+}
+
+function svg(tag, attrs, ...children) {
+  const e = document.createElementNS(svgNS, tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    e.append(c instanceof Node ? c : String(c));
+  }
+  return e;
+}
+
+function table(headers, rows) {
+  return el('table', {},
+    el('thead', {}, el('tr', {}, ...headers.map(h => el('th', {}, h)))),
+    el('tbody', {}, ...rows.map(r => el('tr', {}, ...r.map(c => c instanceof Node && c.tagName === 'TD' ? c : el('td', {}, c))))));
+}
+
+function number(n) {
+  return el('td', {class: 'number'}, n);
+}
+
+function segmentLink(id) {
+  return el('a', {href: '#/segment/' + id, class: 'id'}, id);
+}
+
+function recordLink(id) {
+  return el('a', {href: '#/record/' + id, class: 'id'}, id);
+}
+
+function treeHref(path) {
+  return '#/tree' + path.split('/').map(encodeURIComponent).join('/');
+}
+
+function show(title, ...children) {
+  main.replaceChildren(el('h2', {}, title), ...children);
+}
+
+async function showTree(path) {
+  const node = await api('nodes' + path.split('/').map(encodeURIComponent).join('/'));
+
+  const names = node.path.split('/').filter(n => n !== '');
+  const breadcrumbs = el('p', {class: 'breadcrumbs'}, el('a', {href: treeHref('/')}, '/'));
+  names.forEach((name, i) => {
+    if (i > 0) {
+      breadcrumbs.append('/');
+    }
+    breadcrumbs.append(el('a', {href: treeHref('/' + names.slice(0, i + 1).join('/'))}, name));
+  });
+
+  const children = node.children.map(c => [
+    el('a', {href: treeHref((node.path === '/' ? '' : node.path) + '/' + c.name)}, c.name),
+    recordLink(c.recordId),
+  ]);
+
+  const properties = [];
+  for (const p of node.properties) {
+    p.values.forEach((v, i) => {
+      let value;
+      if (v.blobId !== undefined) {
+        value = 'blob ' + v.blobId;
+      } else if (v.length !== undefined) {
+        value = v.length + ' bytes';
+      } else if (v.value.length > 200) {
+        value = v.value.slice(0, 200) + '…';
+      } else {
+        value = v.value;
+      }
+      properties.push([i === 0 ? p.name : '', i === 0 ? p.type + (p.array ? '[]' : '') : '', value, recordLink(v.recordId)]);
+    });
+  }
+
+  show('Node ' + node.path,
+    breadcrumbs,
+    el('p', {}, 'Node record ', recordLink(node.recordId)),
+    el('h3', {}, 'Children'),
+    table(['Name', 'Record'], children),
+    el('h3', {}, 'Properties'),
+    table(['Name', 'Type', 'Value', 'Record'], properties));
+}
+
+async function showTars() {
+  const tars = await api('tars');
+  const rows = [];
+
+  for (const t of tars) {
+    const index = await api('tars/' + encodeURIComponent(t.name) + '/index');
+    const range = key => {
+      if (index.length === 0) {
+        return '-';
+      }
+      const values = index.map(e => e[key]);
+      const min = Math.min(...values);
+      const max = Math.max(...values);
+      return min === max ? String(min) : min + '–' + max;
+    };
+    rows.push([
+      el('a', {href: '#/tar/' + encodeURIComponent(t.name)}, t.name),
+      number(index.filter(e => e.type === 'data').length),
+      number(index.filter(e => e.type === 'bulk').length),
+      number(t.size),
+      range('generation'),
+      range('fullGeneration'),
+    ]);
+  }
+
+  show('TAR files', table(['Name', 'Data segments', 'Bulk segments', 'Size', 'Generation', 'Full generation'], rows));
+}
+
+async function showTar(name) {
+  const index = await api('tars/' + encodeURIComponent(name) + '/index');
+  show('TAR file ' + name, table(['Segment', 'Type', 'Size', 'Generation', 'Full generation', 'Compacted'],
+    index.map(e => [segmentLink(e.segmentId), e.type, number(e.size), number(e.generation), number(e.fullGeneration), String(e.compacted)])));
+}
+
+async function showSegment(id) {
+  const neighbourhood = await api('graph/' + id);
+  const s = neighbourhood.segment;
+
+  if (s.missing) {
+    show('Segment ' + s.segmentId,
+      el('p', {class: 'missing'}, 'The segment is referenced, but is not in any TAR file.'),
+      el('h3', {}, 'Neighbourhood'),
+      neighbourhoodDiagram(neighbourhood));
+    return;
+  }
+
+  const segment = await api('segments/' + id);
+
+  const header = table(['Field', 'Value'], [
+    ['TAR file', s.tar ? el('a', {href: '#/tar/' + encodeURIComponent(s.tar)}, s.tar) : '-'],
+    ['Type', segment.type],
+    ['Version', segment.type === 'data' ? segment.version : '-'],
+    ['Generation', s.generation],
+    ['Full generation', s.fullGeneration],
+    ['Compacted', String(s.compacted)],
+  ]);
+
+  const uuid = segment.segmentId.replace(/^(.{8})(.{4})(.{4})(.{4})(.{12})$/, '$1-$2-$3-$4-$5');
+
+  show('Segment ' + segment.segmentId,
+    header,
+    el('h3', {}, 'Neighbourhood'),
+    neighbourhoodDiagram(neighbourhood),
+    el('h3', {}, 'References'),
+    table(['Number', 'Segment'], segment.references.map(r => [number(r.number), segmentLink(r.segmentId)])),
+    el('h3', {}, 'Records'),
+    table(['Number', 'Type', 'Offset'], segment.records.map(r => [
+      recordLink(uuid + '.' + r.number.toString(16).padStart(8, '0')),
+      r.type,
+      number(r.offset.toString(16)),
+    ])));
+}
+
+// neighbourhoodDiagram draws the segments referencing a segment on the left,
+// the segment in the middle, and the segments it references on the right.
+function neighbourhoodDiagram(n) {
+  const width = 240, height = 40, gap = 10, columns = [20, 340, 660];
+  const rows = Math.max(n.referrers.length, n.references.length, 1);
+  const root = svg('svg', {width: columns[2] + width + 20, height: rows * (height + gap) + gap});
+  const middle = gap + (rows - 1) * (height + gap) / 2;
+
+  const box = (s, x, y, center) => {
+    const label = s.missing ? 'missing' : s.tar + ' ' + s.generation + '/' + s.fullGeneration;
+    root.append(svg('a', {href: '#/segment/' + s.segmentId},
+      svg('rect', {x: x, y: y, width: width, height: height, rx: 4,
+        fill: center ? '#dde8f3' : 'white', stroke: s.missing ? '#b00' : '#2d3e50',
+        'stroke-dasharray': s.type === 'bulk' ? '4 2' : 'none'}),
+      svg('text', {x: x + 6, y: y + 16}, s.type + ' ' + s.segmentId.slice(0, 16)),
+      svg('text', {x: x + 6, y: y + 32}, label)));
+  };
+
+  const line = (x1, y1, x2, y2) => {
+    root.append(svg('line', {x1: x1, y1: y1 + height / 2, x2: x2, y2: y2 + height / 2, stroke: '#888'}));
+  };
+
+  n.referrers.forEach((s, i) => {
+    const y = gap + i * (height + gap);
+    line(columns[0] + width, y, columns[1], middle);
+    box(s, columns[0], y, false);
+  });
+
+  n.references.forEach((s, i) => {
+    const y = gap + i * (height + gap);
+    line(columns[1] + width, middle, columns[2], y);
+    box(s, columns[2], y, false);
+  });
+
+  box(n.segment, columns[1], middle, true);
+
+  return root;
+}
+
+async function showRecord(id) {
+  const r = await api('records/' + id);
+
+  const rows = [
+    ['Segment', segmentLink(r.segmentId)],
+    ['Type', r.type],
+    ['Size', r.size],
+  ];
+
+  if (r.length !== undefined) {
+    rows.push(['Length', r.length]);
+  }
+
+  if (r.blobId) {
+    rows.push(['Blob ID', r.blobId]);
+  }
+
+  if (!r.node) {
+    show('Record ' + r.recordId, table(['Field', 'Value'], rows));
+    return;
+  }
+
+  rows.push(['Stable ID', recordLink(r.node.stableId)], ['Template', recordLink(r.node.template)]);
+
+  show('Record ' + r.recordId,
+    table(['Field', 'Value'], rows),
+    el('h3', {}, 'Children'),
+    table(['Name', 'Record'], r.node.children.map(c => [c.name, recordLink(c.recordId)])),
+    el('h3', {}, 'Properties'),
+    table(['Name', 'Type', 'Records'], r.node.properties.map(p => [
+      p.name,
+      p.type + (p.array ? '[]' : ''),
+      el('span', {}, ...p.values.flatMap(v => [recordLink(v), ' '])),
+    ])));
+}
+
+async function showJournal() {
+  const entries = await api('journal');
+  show('Journal', table(['Super root', 'Time'], entries.reverse().map(e => [recordLink(e.recordId), e.time || '-'])));
+}
+
+async function route() {
+  const hash = decodeURIComponent(location.hash.slice(1)) || '/tree/';
+  const [, page, arg] = hash.match(/^\/([^/]*)\/?(.*)$/) || [];
+
+  try {
+    main.replaceChildren(el('p', {}, 'Loading…'));
+    switch (page) {
+      case 'tree':
+        await showTree('/' + arg);
+        break;
+      case 'tars':
+        await showTars();
+        break;
+      case 'tar':
+        await showTar(arg);
+        break;
+      case 'segment':
+        await showSegment(arg);
+        break;
+      case 'record':
+        await showRecord(arg);
+        break;
+      case 'journal':
+        await showJournal();
+        break;
+      default:
+        show('Page not found');
+    }
+  } catch (e) {
+    show('Error', el('p', {class: 'error'}, e.message));
+  }
+}
+
+document.getElementById('search').addEventListener('submit', e => {
+  e.preventDefault();
+  const id = document.getElementById('search-id').value.trim();
+  location.hash = (/[.:]/.test(id) ? '#/record/' : '#/segment/') + id;
+});
+
+window.addEventListener('hashchange', route);
+
+route();
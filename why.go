@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"./record"
+	"./store"
+)
+
+// printWhy prints, for the head revision and for every checkpoint, a shortest
+// chain of references from the segment of the root to the segment 'id'. Every
+// segment in the chain is printed with the path of a node stored in it, if any.
+func printWhy(directory, id string, w io.Writer) error {
+	sid, err := store.ParseSegmentID(id)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		return err
+	}
+
+	target := g.Segment(sid)
+
+	if target == nil {
+		return fmt.Errorf("segment %s is not in the store and is not referenced", sid)
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		return fmt.Errorf("unable to read the journal: %v", err)
+	}
+
+	var (
+		r     = newRecordReader(s)
+		paths = make(map[store.SegmentID]string)
+	)
+
+	roots, err := readRoots(r, head)
+	if err != nil {
+		return err
+	}
+
+	err = walkTree(r, head, "/", func(path string, id record.ID, _ *record.NodeRecord, err error) error {
+		if _, ok := paths[recordSegmentID(id)]; !ok && err == nil {
+			paths[recordSegmentID(id)] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		fmt.Fprintf(w, "root %s %s\n", root.path, root.id)
+
+		chain := shortestChain(g.Segment(recordSegmentID(root.id)), target)
+
+		if chain == nil {
+			fmt.Fprintln(w, "unreachable")
+			continue
+		}
+
+		for i, c := range chain {
+			var (
+				tar  = c.Tar
+				path = paths[c.ID]
+			)
+			if c.Missing {
+				tar = "-"
+			}
+			if path == "" {
+				path = "-"
+			}
+			fmt.Fprintf(w, "chain %d %s %s %s %d %s\n", i, segmentType(c.ID.String()), c.ID, tar, c.Generation, path)
+		}
+	}
+
+	return nil
+}
+
+// shortestChain returns a shortest chain of references from 'from' to 'to',
+// both included, or nil if 'to' is not reachable from 'from'.
+func shortestChain(from, to *store.GraphSegment) []*store.GraphSegment {
+	if from == nil {
+		return nil
+	}
+
+	var (
+		parents = map[*store.GraphSegment]*store.GraphSegment{from: nil}
+		queue   = []*store.GraphSegment{from}
+	)
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		if s == to {
+			var chain []*store.GraphSegment
+			for ; s != nil; s = parents[s] {
+				chain = append([]*store.GraphSegment{s}, chain...)
+			}
+			return chain
+		}
+
+		for _, r := range s.References {
+			if _, ok := parents[r]; !ok {
+				parents[r] = s
+				queue = append(queue, r)
+			}
+		}
+	}
+
+	return nil
+}
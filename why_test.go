@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"./store"
+)
+
+func TestShortestChain(t *testing.T) {
+	segments := make(map[string]*store.GraphSegment)
+
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		segments[name] = &store.GraphSegment{}
+	}
+
+	reference := func(from, to string) {
+		segments[from].References = append(segments[from].References, segments[to])
+	}
+
+	// There are two chains from a to d, and the shorter one is found even if
+	// the longer one is explored first. The segment f references a, but is
+	// not reachable from it.
+
+	reference("a", "b")
+	reference("b", "c")
+	reference("c", "d")
+	reference("a", "e")
+	reference("e", "d")
+	reference("d", "a")
+	reference("f", "a")
+
+	chain := shortestChain(segments["a"], segments["d"])
+
+	if want := []*store.GraphSegment{segments["a"], segments["e"], segments["d"]}; len(chain) != len(want) || chain[0] != want[0] || chain[1] != want[1] || chain[2] != want[2] {
+		t.Fatalf("unexpected chain %v", chain)
+	}
+
+	if chain := shortestChain(segments["a"], segments["a"]); len(chain) != 1 || chain[0] != segments["a"] {
+		t.Fatalf("unexpected chain to the same segment %v", chain)
+	}
+
+	if chain := shortestChain(segments["a"], segments["f"]); chain != nil {
+		t.Fatalf("unexpected chain to an unreachable segment %v", chain)
+	}
+
+	if chain := shortestChain(nil, segments["a"]); chain != nil {
+		t.Fatalf("unexpected chain from a missing segment %v", chain)
+	}
+}
+
+func TestWhy(t *testing.T) {
+	directory := t.TempDir()
+
+	o := synthOptions{
+		seed:        1,
+		tars:        2,
+		generations: 3,
+		depth:       2,
+		fanout:      3,
+		properties:  3,
+		journal:     6,
+	}
+
+	if err := synthesize(directory, o); err != nil {
+		t.Fatalf("unable to synthesize the store: %v", err)
+	}
+
+	s, err := store.Open(directory)
+	if err != nil {
+		t.Fatalf("unable to open the store: %v", err)
+	}
+
+	g, err := s.ReadGraph()
+	if err != nil {
+		t.Fatalf("unable to read the graph: %v", err)
+	}
+
+	head, err := s.Head()
+	if err != nil {
+		t.Fatalf("unable to read the journal: %v", err)
+	}
+
+	from := g.Segment(recordSegmentID(head))
+
+	var reachable, unreachable *store.GraphSegment
+
+	reached := make(map[*store.GraphSegment]bool)
+
+	for _, gs := range g.Reachable(from) {
+		reached[gs] = true
+		if gs != from && reachable == nil {
+			reachable = gs
+		}
+	}
+
+	for _, gs := range g.Segments {
+		if !reached[gs] && !gs.Missing {
+			unreachable = gs
+			break
+		}
+	}
+
+	if reachable == nil || unreachable == nil {
+		t.Fatalf("no reachable or unreachable segment")
+	}
+
+	var b bytes.Buffer
+
+	if err := printWhy(directory, reachable.ID.String(), &b); err != nil {
+		t.Fatalf("unable to explain %s: %v", reachable.ID, err)
+	}
+
+	lines := strings.Split(b.String(), "\n")
+
+	if !strings.HasPrefix(lines[0], "root / "+head.String()) {
+		t.Fatalf("unexpected root:\n%s", b.String())
+	}
+
+	if !strings.HasPrefix(lines[1], "chain 0 data "+from.ID.String()+" ") || !strings.HasSuffix(lines[1], " /") {
+		t.Fatalf("chain doesn't start from the head:\n%s", b.String())
+	}
+
+	// The chain ends at the segment, after the segments referencing it.
+
+	var last string
+
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "chain ") {
+			break
+		}
+		last = line
+	}
+
+	if fields := strings.Fields(last); len(fields) < 4 || fields[3] != reachable.ID.String() {
+		t.Fatalf("chain doesn't end at %s:\n%s", reachable.ID, b.String())
+	}
+
+	b.Reset()
+
+	if err := printWhy(directory, unreachable.ID.String(), &b); err != nil {
+		t.Fatalf("unable to explain %s: %v", unreachable.ID, err)
+	}
+
+	if lines := strings.Split(b.String(), "\n"); len(lines) < 2 || lines[1] != "unreachable" {
+		t.Fatalf("unreachable segment not reported:\n%s", b.String())
+	}
+}